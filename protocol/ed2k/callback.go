@@ -0,0 +1,182 @@
+package ed2k
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultCallbackTimeout is the default maximum duration to wait for a callback connection.
+	DefaultCallbackTimeout = 45 * time.Second
+)
+
+// errors
+var (
+	ErrCallbackFailed  = errors.New("callback failed")
+	ErrCallbackTimeout = errors.New("callback timeout")
+)
+
+type callbackResult struct {
+	conn  *Conn
+	hello *HelloMessage
+	err   error
+}
+
+type pendingCallback struct {
+	id ClientID
+	ch chan callbackResult
+}
+
+// CallbackCoordinator coordinates the low ID callbacks through the server.
+//
+// A high ID client can't connect to a low ID client, instead it asks the server to make the
+// low ID client connect back with Request. The incoming connections must be passed to HandleHello,
+// which matches the hello ClientID against the pending requests.
+//
+// On the low ID side, HandleMessage honors the callback requested messages of the server by dialing
+// the requesting client, the connection is passed to OnCallback and the failures to OnError.
+type CallbackCoordinator struct {
+	// Server sends the callback requests, e.g. a ServerSession.
+	Server MessageWriter
+	// Timeout is the maximum duration to wait for a callback connection, DefaultCallbackTimeout if 0.
+	Timeout time.Duration
+	// Dialer dials the requesting clients, a net.Dialer is used if nil.
+	Dialer interface {
+		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	}
+	// OnCallback is called with the connection dialed for a callback request,
+	// it should send the hello message. The connection is closed if OnCallback is nil.
+	OnCallback func(conn *Conn)
+	// OnError, if not nil, is called with the callback request m when the requesting client can't be dialed.
+	// err is ErrCallbackTimeout if the dial does not complete in time, or the dial error otherwise.
+	OnError func(m *CallbackRequestedMessage, err error)
+
+	mu      sync.Mutex
+	pending []*pendingCallback // oldest first
+}
+
+// Request asks the server to make the low ID client id connect back, and waits for the connection.
+// It returns the connection and its hello message, ErrCallbackFailed if the server reports
+// the callback failed, or ErrCallbackTimeout if the client does not connect in time.
+func (c *CallbackCoordinator) Request(ctx context.Context, id ClientID) (*Conn, *HelloMessage, error) {
+	p := &pendingCallback{id: id, ch: make(chan callbackResult, 1)}
+	c.mu.Lock()
+	c.pending = append(c.pending, p)
+	c.mu.Unlock()
+
+	if err := c.Server.WriteMessage(&CallbackRequestMessage{ClientID: id}); err != nil {
+		c.remove(p)
+		return nil, nil, err
+	}
+
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCallbackTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case r := <-p.ch:
+		return r.conn, r.hello, r.err
+	case <-timer.C:
+		if !c.remove(p) {
+			// completed concurrently.
+			r := <-p.ch
+			return r.conn, r.hello, r.err
+		}
+		return nil, nil, ErrCallbackTimeout
+	case <-ctx.Done():
+		if !c.remove(p) {
+			r := <-p.ch
+			if r.conn != nil {
+				r.conn.Close()
+			}
+		}
+		return nil, nil, ctx.Err()
+	}
+}
+
+// remove removes the pending request p, it returns false if p is not pending anymore.
+func (c *CallbackCoordinator) remove(p *pendingCallback) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, q := range c.pending {
+		if q == p {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// HandleHello matches the incoming connection conn, with the hello message already read,
+// against the pending requests. It returns true if the connection is handed over to a request.
+func (c *CallbackCoordinator) HandleHello(conn *Conn, hello *HelloMessage) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, p := range c.pending {
+		if p.id == hello.ClientID {
+			c.pending = append(c.pending[:i], c.pending[i+1:]...)
+			p.ch <- callbackResult{conn: conn, hello: hello}
+			return true
+		}
+	}
+	return false
+}
+
+// HandleMessage handles the callback messages of the server, it returns false for other messages.
+// A callback failed message fails the oldest pending request, as the server does not tell which one failed.
+// A callback requested message dials the requesting client in a new goroutine.
+func (c *CallbackCoordinator) HandleMessage(ctx context.Context, m Message) bool {
+	switch msg := m.(type) {
+	case *CallbackFailedMessage:
+		c.mu.Lock()
+		if len(c.pending) > 0 {
+			p := c.pending[0]
+			c.pending = c.pending[1:]
+			p.ch <- callbackResult{err: ErrCallbackFailed}
+		}
+		c.mu.Unlock()
+		return true
+	case *CallbackRequestedMessage:
+		go c.callback(ctx, msg)
+		return true
+	}
+	return false
+}
+
+func (c *CallbackCoordinator) callback(ctx context.Context, m *CallbackRequestedMessage) {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultCallbackTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	dialer := c.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	addr := net.JoinHostPort(ClientID(m.IP).String(), strconv.Itoa(int(m.Port)))
+	nc, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		if ctx.Err() == context.DeadlineExceeded {
+			err = ErrCallbackTimeout
+		}
+		if c.OnError != nil {
+			c.OnError(m, err)
+		}
+		return
+	}
+	conn := NewConn(nc, CCTCPMessage)
+	if c.OnCallback == nil {
+		conn.Close()
+		return
+	}
+	c.OnCallback(conn)
+}
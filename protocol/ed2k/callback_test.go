@@ -0,0 +1,142 @@
+package ed2k
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestCallbackRequest(t *testing.T) {
+	server := &messageRecorder{written: make(chan Message, 4)}
+	c := &CallbackCoordinator{Server: server, Timeout: time.Second}
+
+	type result struct {
+		conn *Conn
+		err  error
+	}
+	request := func(id ClientID) chan result {
+		ch := make(chan result, 1)
+		go func() {
+			conn, _, err := c.Request(context.Background(), id)
+			ch <- result{conn, err}
+		}()
+		if m := (<-server.written).(*CallbackRequestMessage); m.ClientID != id {
+			t.Errorf("callback request for %d, want %d", m.ClientID, id)
+		}
+		return ch
+	}
+
+	r1 := request(1)
+	r2 := request(2)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+	conn := NewConn(c1, CCTCPMessage)
+	if c.HandleHello(conn, &HelloMessage{ClientID: 9}) {
+		t.Error("unexpected hello matched")
+	}
+	if !c.HandleHello(conn, &HelloMessage{ClientID: 2}) {
+		t.Error("hello not matched")
+	}
+	if r := <-r2; r.err != nil || r.conn != conn {
+		t.Errorf("request 2: %v", r.err)
+	}
+
+	// the oldest request fails.
+	if !c.HandleMessage(context.Background(), &CallbackFailedMessage{}) {
+		t.Error("callback failed not handled")
+	}
+	if r := <-r1; r.err != ErrCallbackFailed {
+		t.Errorf("request 1: got %v, want %v", r.err, ErrCallbackFailed)
+	}
+
+	c.Timeout = 10 * time.Millisecond
+	if r := <-request(3); r.err != ErrCallbackTimeout {
+		t.Errorf("request 3: got %v, want %v", r.err, ErrCallbackTimeout)
+	}
+	if c.HandleHello(conn, &HelloMessage{ClientID: 3}) {
+		t.Error("timed out request matched")
+	}
+}
+
+func TestCallbackRequested(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// the port is little endian.
+	m := &CallbackRequestedMessage{}
+	port := ln.Addr().(*net.TCPAddr).Port
+	data := []byte{ProtoEDonkey, 7, 0, 0, 0, MessageCallbackRequested, 127, 0, 0, 1, uint8(port), uint8(port >> 8)}
+	if err := m.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if int(m.Port) != port {
+		t.Fatalf("got port %d, want %d", m.Port, port)
+	}
+
+	called := make(chan *Conn, 1)
+	c := &CallbackCoordinator{OnCallback: func(conn *Conn) { called <- conn }}
+	if !c.HandleMessage(context.Background(), m) {
+		t.Fatal("callback requested not handled")
+	}
+	nc, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer nc.Close()
+	select {
+	case conn := <-called:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Error("no callback connection")
+	}
+	if c.HandleMessage(context.Background(), &ServerStatusMessage{}) {
+		t.Error("unexpected message handled")
+	}
+}
+
+func TestCallbackRequestedError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+
+	m := &CallbackRequestedMessage{IP: 0x0100007f, Port: uint16(port)}
+	errs := make(chan error, 1)
+	c := &CallbackCoordinator{
+		OnCallback: func(conn *Conn) { conn.Close() },
+		OnError: func(msg *CallbackRequestedMessage, err error) {
+			if msg != m {
+				t.Errorf("got message %v, want %v", msg, m)
+			}
+			errs <- err
+		},
+	}
+	c.HandleMessage(context.Background(), m)
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("dial to a closed listener succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no callback error")
+	}
+
+	c.Timeout = time.Nanosecond
+	c.HandleMessage(context.Background(), m)
+	select {
+	case err := <-errs:
+		if err != ErrCallbackTimeout {
+			t.Errorf("got %v, want %v", err, ErrCallbackTimeout)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no callback timeout")
+	}
+}
@@ -0,0 +1,143 @@
+package ed2k
+
+import (
+	"fmt"
+)
+
+// PackEMuleVersion packs the client version in the CT_EMULE_VERSION form:
+// the client kind in bits 24-31, major in bits 17-23, minor in bits 10-16 and update in bits 7-9.
+func PackEMuleVersion(kind, major, minor, update uint8) uint32 {
+	return uint32(kind)<<24 | uint32(major&0x7F)<<17 | uint32(minor&0x7F)<<10 | uint32(update&0x07)<<7
+}
+
+// UnpackEMuleVersion unpacks the CT_EMULE_VERSION value v packed by PackEMuleVersion.
+func UnpackEMuleVersion(v uint32) (kind, major, minor, update uint8) {
+	return uint8(v >> 24), uint8(v >> 17 & 0x7F), uint8(v >> 10 & 0x7F), uint8(v >> 7 & 0x07)
+}
+
+// ClientSoftware is the software of a client.
+type ClientSoftware struct {
+	// The client kind, Client* constants.
+	Kind   uint8
+	Major  uint8
+	Minor  uint8
+	Update uint8
+	// The mod name, if any.
+	Mod string
+}
+
+var clientNames = map[uint8]string{
+	ClientEMule:         "eMule",
+	ClientCDonkey:       "cDonkey",
+	ClientLXMule:        "xMule",
+	ClientAMule:         "aMule",
+	ClientShareaza:      "Shareaza",
+	ClientEMulePlus:     "eMule Plus",
+	ClientHydraNode:     "Hydranode",
+	ClientLPhant:        "lphant",
+	ClientEDonkeyHybrid: "eDonkeyHybrid",
+	ClientEDonkey:       "eDonkey",
+	ClientMLDonkey:      "MLdonkey",
+	ClientEMuleOld:      "Old eMule",
+	ClientUnknown:       "Unknown",
+	ClientCompat:        "eMule Compat",
+}
+
+func (s ClientSoftware) String() string {
+	name, ok := clientNames[s.Kind]
+	if !ok {
+		name = fmt.Sprintf("Client(%#x)", s.Kind)
+	}
+	var v string
+	switch s.Kind {
+	case ClientEMule, ClientEMuleOld:
+		// eMule versions are like 0.50a.
+		v = fmt.Sprintf("%s v%d.%d%c", name, s.Major, s.Minor, 'a'+s.Update)
+	default:
+		v = fmt.Sprintf("%s v%d.%d.%d", name, s.Major, s.Minor, s.Update)
+	}
+	if s.Mod != "" {
+		v += " [" + s.Mod + "]"
+	}
+	return v
+}
+
+// compatibleClient returns the client kind of the compatible client ID sent in CT_EMULE_VERSION or ET_COMPATIBLECLIENT.
+func compatibleClient(id uint8) uint8 {
+	switch id {
+	case ClientEMule, ClientCDonkey, ClientLXMule, ClientAMule, ClientShareaza,
+		ClientEMulePlus, ClientHydraNode, ClientLPhant:
+		return id
+	case ClientShareazaNew, ClientShareazaNew2:
+		return ClientShareaza
+	case ClientMLDonkeyNew, ClientMLDonkeyNew2:
+		return ClientMLDonkey
+	}
+	return ClientCompat
+}
+
+// DetectClientSoftware identifies the client software from the user hash marker bytes, the hello tags
+// and the eMule info message, which is nil if it is not sent by the client.
+//
+// The eMule compatible clients announce the kind and version in the CT_EMULE_VERSION tag, older ones
+// in the eMule info message. Other clients are told apart by the user hash markers (see NewUID).
+func DetectClientSoftware(uid UID, tags Tags, info *EMuleInfoMessage) ClientSoftware {
+	s := ClientSoftware{Kind: ClientEDonkey}
+	if mod, ok := tags.String(CTModVersion); ok {
+		s.Mod = mod
+	} else if id, ok := tags.Uint32(CTModVersion); ok {
+		s.Mod = fmt.Sprintf("ModID=%d", id)
+	}
+	if info != nil && s.Mod == "" {
+		s.Mod, _ = info.Tags.String(CTModVersion)
+	}
+
+	if v, ok := tags.Uint32(CTEMuleVersion); ok {
+		var kind uint8
+		kind, s.Major, s.Minor, s.Update = UnpackEMuleVersion(v)
+		s.Kind = compatibleClient(kind)
+		return s
+	}
+	if info != nil {
+		s.Kind = ClientEMule
+		if id, ok := info.Tags.Uint32(ETCompatibleClient); ok {
+			s.Kind = compatibleClient(uint8(id))
+		}
+		// the old version byte is like 0x30 for 0.30.
+		if v := info.ClientVersion; v != 0 && v != 0x99 {
+			s.Minor = v>>4*10 + v&0x0F
+		}
+		return s
+	}
+
+	switch {
+	case uid[5] == 14 && uid[14] == 111:
+		s.Kind = ClientEMule
+	case uid[5] == 13 && uid[14] == 110:
+		s.Kind = ClientEMuleOld
+	case uid[5] == 'M' && uid[14] == 'L':
+		s.Kind = ClientMLDonkey
+	default:
+		// eDonkey versions are like 10300 for 1.3.0, or 1060 for 0.60.
+		v, _ := tags.Uint32(CTVersion)
+		switch {
+		case v > 10000:
+			s.Major, s.Minor, s.Update = 1, uint8((v-10000)/100), uint8((v-10000)%100)
+		case v > 1000:
+			s.Minor = uint8(v - 1000)
+		default:
+			s.Minor = uint8(v)
+		}
+	}
+	return s
+}
+
+// ClientSoftware returns the client software identified from the hello message.
+func (m *HelloMessage) ClientSoftware() ClientSoftware {
+	return DetectClientSoftware(m.UID, m.Tags, nil)
+}
+
+// ClientSoftware returns the client software identified from the hello answer message.
+func (m *HelloAnswerMessage) ClientSoftware() ClientSoftware {
+	return DetectClientSoftware(m.UID, m.Tags, nil)
+}
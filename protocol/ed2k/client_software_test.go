@@ -0,0 +1,104 @@
+package ed2k
+
+import (
+	"testing"
+)
+
+func TestEMuleVersion(t *testing.T) {
+	if v := PackEMuleVersion(ClientAMule, MajorVersion, MinorVersion, UpdateVersion); v != EMuleVersion {
+		t.Errorf("packed version %#x, want %#x", v, EMuleVersion)
+	}
+	kind, major, minor, update := UnpackEMuleVersion(PackEMuleVersion(ClientEMule, 0, 50, 1))
+	if kind != ClientEMule || major != 0 || minor != 50 || update != 1 {
+		t.Errorf("unpacked version %d %d.%d.%d", kind, major, minor, update)
+	}
+}
+
+func TestDetectClientSoftware(t *testing.T) {
+	emule := UID{5: 14, 14: 111}
+	testCases := []struct {
+		uid  UID
+		tags Tags
+		info *EMuleInfoMessage
+		s    ClientSoftware
+		str  string
+	}{
+		{
+			emule,
+			Tags{Uint32Tag(CTEMuleVersion, PackEMuleVersion(ClientEMule, 0, 50, 0))},
+			nil,
+			ClientSoftware{Kind: ClientEMule, Minor: 50},
+			"eMule v0.50a",
+		},
+		{
+			emule,
+			Tags{
+				StringTag(CTModVersion, "Xtreme 8.1", false),
+				Uint32Tag(CTEMuleVersion, PackEMuleVersion(ClientEMule, 0, 48, 2)),
+			},
+			nil,
+			ClientSoftware{Kind: ClientEMule, Minor: 48, Update: 2, Mod: "Xtreme 8.1"},
+			"eMule v0.48c [Xtreme 8.1]",
+		},
+		{
+			emule,
+			Tags{Uint32Tag(CTEMuleVersion, EMuleVersion)},
+			nil,
+			ClientSoftware{Kind: ClientAMule, Major: MajorVersion, Minor: MinorVersion, Update: UpdateVersion},
+			"aMule v2.4.0",
+		},
+		{
+			UID{},
+			Tags{Uint32Tag(CTEMuleVersion, PackEMuleVersion(ClientShareazaNew, 2, 7, 1))},
+			nil,
+			ClientSoftware{Kind: ClientShareaza, Major: 2, Minor: 7, Update: 1},
+			"Shareaza v2.7.1",
+		},
+		{
+			UID{},
+			Tags{Uint32Tag(CTEMuleVersion, PackEMuleVersion(0x77, 1, 0, 0))},
+			nil,
+			ClientSoftware{Kind: ClientCompat, Major: 1},
+			"eMule Compat v1.0.0",
+		},
+		{
+			emule,
+			nil,
+			&EMuleInfoMessage{ClientVersion: 0x30, Tags: Tags{StringTag(CTModVersion, "Morph", false)}},
+			ClientSoftware{Kind: ClientEMule, Minor: 30, Mod: "Morph"},
+			"eMule v0.30a [Morph]",
+		},
+		{
+			emule,
+			nil,
+			&EMuleInfoMessage{ClientVersion: 0x99, Tags: Tags{Uint32Tag(ETCompatibleClient, ClientLXMule)}},
+			ClientSoftware{Kind: ClientLXMule},
+			"xMule v0.0.0",
+		},
+		{UID{5: 13, 14: 110}, nil, nil, ClientSoftware{Kind: ClientEMuleOld}, "Old eMule v0.0a"},
+		{UID{5: 'M', 14: 'L'}, nil, nil, ClientSoftware{Kind: ClientMLDonkey}, "MLdonkey v0.0.0"},
+		{
+			UID{},
+			Tags{Uint32Tag(CTVersion, 10300)},
+			nil,
+			ClientSoftware{Kind: ClientEDonkey, Major: 1, Minor: 3},
+			"eDonkey v1.3.0",
+		},
+		{UID{}, Tags{Uint32Tag(CTVersion, 1060)}, nil, ClientSoftware{Kind: ClientEDonkey, Minor: 60}, "eDonkey v0.60.0"},
+	}
+
+	for i, tc := range testCases {
+		s := DetectClientSoftware(tc.uid, tc.tags, tc.info)
+		if s != tc.s {
+			t.Errorf("%d: got %+v, want %+v", i, s, tc.s)
+		}
+		if s.String() != tc.str {
+			t.Errorf("%d: got %s, want %s", i, s, tc.str)
+		}
+	}
+
+	hello := &HelloMessage{UID: NewUID(), Tags: (&PeerCapabilities{Name: "gmule"}).Tags()}
+	if s := hello.ClientSoftware(); s.Kind != ClientAMule || s.Major != MajorVersion || s.Minor != MinorVersion {
+		t.Errorf("hello client software %s", s)
+	}
+}
@@ -0,0 +1,191 @@
+package ed2k
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxPacketSize is the default maximum size of a message read from a Conn, not including the header.
+	DefaultMaxPacketSize = 2 << 20
+)
+
+// ConnStats is the per-connection traffic counters.
+type ConnStats struct {
+	BytesRead       uint64
+	BytesWritten    uint64
+	MessagesRead    uint64
+	MessagesWritten uint64
+}
+
+// Conn is a framed message connection over a net.Conn.
+// ReadMessage should be called from a single goroutine, while WriteMessage is safe for concurrent use.
+type Conn struct {
+	// keep 64-bit counters first for atomic access on 32-bit platforms.
+	stats ConnStats
+
+	// ReadTimeout is the maximum duration for reading a message, zero means no timeout.
+	ReadTimeout time.Duration
+	// WriteTimeout is the maximum duration for writing a message, zero means no timeout.
+	WriteTimeout time.Duration
+	// MaxPacketSize is the maximum size of a message read from the connection,
+	// not including the header. Zero means no limit.
+	MaxPacketSize int
+	// TagDecoder decodes the tags of the messages read, DefaultTagDecoder is used if it is nil.
+	TagDecoder *TagDecoder
+
+	conn  net.Conn
+	class int
+
+	rmu sync.Mutex
+	r   *bufio.Reader
+
+	wmu   sync.Mutex
+	w     *bufio.Writer
+	flags uint32
+}
+
+// NewConn returns a Conn that reads and writes messages of class on conn.
+func NewConn(conn net.Conn, class int) *Conn {
+	return &Conn{
+		MaxPacketSize: DefaultMaxPacketSize,
+		conn:          conn,
+		class:         class,
+		r:             bufio.NewReader(conn),
+		w:             bufio.NewWriter(conn),
+	}
+}
+
+// ReadMessage reads the next message from the connection.
+func (c *Conn) ReadMessage() (m Message, err error) {
+	return c.ReadMessageContext(context.Background())
+}
+
+// ReadMessageContext reads the next message from the connection, the read is interrupted when ctx is canceled
+// and ctx.Err() is returned. A cancellation in the middle of a message leaves the rest of it in the stream,
+// the connection can't be read anymore and must be closed.
+func (c *Conn) ReadMessageContext(ctx context.Context) (m Message, err error) {
+	c.rmu.Lock()
+	defer c.rmu.Unlock()
+
+	if err = ctx.Err(); err != nil {
+		return
+	}
+	if c.ReadTimeout > 0 {
+		if err = c.conn.SetReadDeadline(time.Now().Add(c.ReadTimeout)); err != nil {
+			return
+		}
+	}
+
+	// the interrupt deadline is set after the read timeout, so it is not extended.
+	if done := ctx.Done(); done != nil {
+		stop := make(chan struct{})
+		interrupted := make(chan bool, 1)
+		go func() {
+			select {
+			case <-done:
+				c.conn.SetReadDeadline(time.Now())
+				interrupted <- true
+			case <-stop:
+				interrupted <- false
+			}
+		}()
+		defer func() {
+			close(stop)
+			if <-interrupted {
+				c.conn.SetReadDeadline(time.Time{})
+				if err != nil {
+					err = ctx.Err()
+				}
+			}
+		}()
+	}
+
+	m, n, err := readMessage(c.r, c.class, c.MaxPacketSize, c.TagDecoder)
+	atomic.AddUint64(&c.stats.BytesRead, uint64(n))
+	if err != nil {
+		return
+	}
+	atomic.AddUint64(&c.stats.MessagesRead, 1)
+	return
+}
+
+// WriteMessage writes the message m to the connection.
+// The message is packed if the peer flags has CapZlib set and the message protocol is the one restored by the reader.
+func (c *Conn) WriteMessage(m Message) error {
+	return c.WriteMessages(m)
+}
+
+// WriteMessages writes the messages to the connection at once, the messages are flushed
+// after the last one is buffered, so no other message can be interleaved.
+func (c *Conn) WriteMessages(messages ...Message) (err error) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+
+	if c.WriteTimeout > 0 {
+		if err = c.conn.SetWriteDeadline(time.Now().Add(c.WriteTimeout)); err != nil {
+			return
+		}
+	}
+
+	for _, m := range messages {
+		var data []byte
+		if data, err = PackMessage(m, c.class, c.flags); err != nil {
+			return
+		}
+		if _, err = c.w.Write(data); err != nil {
+			return
+		}
+		atomic.AddUint64(&c.stats.BytesWritten, uint64(len(data)))
+		atomic.AddUint64(&c.stats.MessagesWritten, 1)
+	}
+	return c.w.Flush()
+}
+
+// SetFlags sets the capabilities advertised by the peer (e.g. CapZlib), which are used to encode messages.
+func (c *Conn) SetFlags(flags uint32) {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.flags = flags
+}
+
+// Flags returns the capabilities advertised by the peer.
+func (c *Conn) Flags() uint32 {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.flags
+}
+
+// Stats returns a snapshot of the connection traffic counters.
+func (c *Conn) Stats() ConnStats {
+	return ConnStats{
+		BytesRead:       atomic.LoadUint64(&c.stats.BytesRead),
+		BytesWritten:    atomic.LoadUint64(&c.stats.BytesWritten),
+		MessagesRead:    atomic.LoadUint64(&c.stats.MessagesRead),
+		MessagesWritten: atomic.LoadUint64(&c.stats.MessagesWritten),
+	}
+}
+
+// LocalAddr returns the local network address.
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address.
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// NetConn returns the underlying connection.
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// Close closes the connection.
+func (c *Conn) Close() error {
+	return c.conn.Close()
+}
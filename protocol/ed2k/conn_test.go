@@ -0,0 +1,180 @@
+package ed2k
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestConnReadWriteMessage(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(c1, CSTCPMessage)
+	server := NewConn(c2, CSTCPMessage)
+	defer client.Close()
+	defer server.Close()
+
+	const writers = 4
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := client.WriteMessage(&ServerStatusMessage{UserCount: uint32(i), FileCount: 1}); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+
+	seen := map[uint32]bool{}
+	for i := 0; i < writers; i++ {
+		m, err := server.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		status, ok := m.(*ServerStatusMessage)
+		if !ok || status.FileCount != 1 {
+			t.Fatalf("unexpected message %v", m)
+		}
+		seen[status.UserCount] = true
+	}
+	wg.Wait()
+	if len(seen) != writers {
+		t.Errorf("got %d distinct messages, want %d", len(seen), writers)
+	}
+
+	cs, ss := client.Stats(), server.Stats()
+	if cs.MessagesWritten != writers || ss.MessagesRead != writers {
+		t.Errorf("message counters: %+v, %+v", cs, ss)
+	}
+	if cs.BytesWritten != writers*14 || cs.BytesWritten != ss.BytesRead {
+		t.Errorf("byte counters: %+v, %+v", cs, ss)
+	}
+}
+
+func TestConnMaxPacketSize(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(c1, CSTCPMessage)
+	server := NewConn(c2, CSTCPMessage)
+	defer client.Close()
+	defer server.Close()
+	server.MaxPacketSize = 8
+
+	go client.WriteMessage(&ServerMessage{Messages: "server version 17.15"})
+
+	if _, err := server.ReadMessage(); err != ErrMessageTooLarge {
+		t.Errorf("got %v, want %v", err, ErrMessageTooLarge)
+	}
+}
+
+func TestConnReadTimeout(t *testing.T) {
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	conn := NewConn(c2, CSTCPMessage)
+	defer conn.Close()
+	conn.ReadTimeout = 10 * time.Millisecond
+
+	_, err := conn.ReadMessage()
+	if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+		t.Errorf("got %v, want timeout", err)
+	}
+}
+
+func TestConnPacked(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(c1, CSTCPMessage)
+	server := NewConn(c2, CSTCPMessage)
+	defer client.Close()
+	defer server.Close()
+	client.SetFlags(CapZlib)
+
+	m := &OfferFilesMessage{}
+	for i := 0; i < 20; i++ {
+		m.Files = append(m.Files, File{Tags: []Tag{StringTag(TagName, "the same file name", false)}})
+	}
+	raw, _ := m.Encode()
+	go client.WriteMessage(m)
+
+	msg, err := server.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if offer, ok := msg.(*OfferFilesMessage); !ok || len(offer.Files) != len(m.Files) {
+		t.Fatalf("unexpected message %v", msg)
+	}
+	if n := server.Stats().BytesRead; n >= uint64(len(raw)) {
+		t.Errorf("message not packed, %d bytes read", n)
+	}
+}
+
+func TestConnPackedClient(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(c1, CCTCPMessage)
+	peer := NewConn(c2, CCTCPMessage)
+	defer client.Close()
+	defer peer.Close()
+	client.SetFlags(CapZlib)
+
+	var tags Tags
+	for i := 0; i < 20; i++ {
+		tags = append(tags, StringTag(TagName, "the same client name", false))
+	}
+	hello := &HelloMessage{UID: NewUID(), ClientID: 0x02000001, Port: 4662, Tags: tags}
+	info := &EMuleInfoMessage{ClientVersion: 0x30, Tags: tags}
+	go client.WriteMessages(hello, info)
+
+	// the eDonkey hello is sent unpacked, it would be read back as eMule info once unpacked.
+	m, err := peer.ReadMessage()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h, ok := m.(*HelloMessage); !ok || h.UID != hello.UID || len(h.Tags) != len(tags) {
+		t.Fatalf("unexpected message %v", m)
+	}
+	n := peer.Stats().BytesRead
+	raw, _ := info.Encode()
+	if m, err = peer.ReadMessage(); err != nil {
+		t.Fatal(err)
+	}
+	if i, ok := m.(*EMuleInfoMessage); !ok || i.ClientVersion != 0x30 || len(i.Tags) != len(tags) {
+		t.Fatalf("unexpected message %v", m)
+	}
+	if n = peer.Stats().BytesRead - n; n >= uint64(len(raw)) {
+		t.Errorf("eMule message not packed, %d bytes read", n)
+	}
+}
+
+func TestConnReadMessageContext(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(c1, CSTCPMessage)
+	server := NewConn(c2, CSTCPMessage)
+	defer client.Close()
+	defer server.Close()
+	client.ReadTimeout = time.Minute
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := client.ReadMessageContext(ctx); err != context.Canceled {
+		t.Errorf("canceled context: got %v, want %v", err, context.Canceled)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, err := client.ReadMessageContext(ctx); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("canceled read returned after %v", d)
+	}
+
+	// the interrupt deadline is cleared.
+	client.ReadTimeout = 0
+	go server.WriteMessage(&ServerStatusMessage{UserCount: 1})
+	if m, err := client.ReadMessageContext(context.Background()); err != nil {
+		t.Errorf("read after cancel: %v", err)
+	} else if status, ok := m.(*ServerStatusMessage); !ok || status.UserCount != 1 {
+		t.Errorf("read after cancel: %v", m)
+	}
+}
@@ -0,0 +1,78 @@
+package ed2k
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+const (
+	// DatagramHeaderLength is the length of UDP message header.
+	// 1-byte protocol + 1-byte message type, UDP messages have no size field.
+	DatagramHeaderLength = 2
+)
+
+// DecodeDatagram parses the UDP datagram data to message of class CSUDPMessage or CCUDPMessage.
+// The message type is looked up in the message registry, unknown opcodes are decoded as RawMessage.
+func DecodeDatagram(data []byte, class int) (m Message, err error) {
+	return decodeDatagram(data, class, nil)
+}
+
+// decodeDatagram decodes the datagram data with the message tags decoded by d, DefaultTagDecoder if d is nil.
+func decodeDatagram(data []byte, class int, d *TagDecoder) (m Message, err error) {
+	if class != CSUDPMessage && class != CCUDPMessage {
+		err = errors.New("not a datagram message class")
+		return
+	}
+	if len(data) < DatagramHeaderLength {
+		err = decodeError(0, len(data), "datagram header", ErrShortBuffer)
+		return
+	}
+	if !validProto(data[0]) {
+		err = decodeError(data[1], 0, "protocol", ErrInvalidProto)
+		return
+	}
+	m = NewMessage(class, data[0], data[1])
+	if err = decodeMessage(m, data, d); err != nil {
+		m = nil
+	}
+	return
+}
+
+func validProto(proto uint8) bool {
+	return proto == ProtoEDonkey || proto == ProtoEMule || proto == ProtoPacked
+}
+
+// writeDatagramHeader writes the protocol of header h and the message type to buf.
+func writeDatagramHeader(buf *bytes.Buffer, h Header, mType uint8) error {
+	proto := h.Protocol
+	if proto == 0 {
+		proto = ProtoEDonkey
+	}
+	if !validProto(proto) {
+		return ErrInvalidProto
+	}
+	buf.WriteByte(proto)
+	buf.WriteByte(mType)
+	return nil
+}
+
+// decodeDatagramHeader checks that the datagram data is a message of mType with a payload of at least min bytes.
+// The size of the returned header is the datagram size not including the protocol, as for TCP messages.
+func decodeDatagramHeader(data []byte, mType uint8, min int) (header Header, err error) {
+	if len(data) < DatagramHeaderLength+min {
+		err = decodeError(mType, len(data), "message payload", ErrShortBuffer)
+		return
+	}
+	if !validProto(data[0]) {
+		err = decodeError(mType, 0, "protocol", ErrInvalidProto)
+		return
+	}
+	if data[1] != mType {
+		err = decodeError(mType, 1, fmt.Sprintf("message type %#x", data[1]), ErrWrongMessageType)
+		return
+	}
+	header.Protocol = data[0]
+	header.Size = uint32(len(data) - 1)
+	return
+}
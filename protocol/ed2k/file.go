@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 
 	"golang.org/x/crypto/md4"
 )
@@ -18,6 +19,11 @@ const (
 	MaxFileSize = 2 << 37
 )
 
+// errors
+var (
+	ErrFileTooLarge = errors.New("file too large")
+)
+
 // ed2k search expression comparison operators.
 // kad operators used to be different, but are the same since eMule 0.47a.
 const (
@@ -53,12 +59,14 @@ const (
 	SearchName     = 0x01
 	SearchMetadata = 0x02
 	SearchLimit    = 0x3
+	SearchLimit64  = 0x08 // numeric limit with 64-bit value, large file capable servers only.
 )
 
-// Limit types
+// Limit types, the operators of the numeric limits of the older servers.
+// They are the SearchGreater and SearchLess operator bytes, e.g. Limit(TagSize, LimitMin, size).
 const (
-	LimitMin = 0x01
-	LimitMax = 0x02
+	LimitMin = SearchGreater
+	LimitMax = SearchLess
 )
 
 // FileHash is a 128 bit GUID hash calculated by the client and based on the file's contents.
@@ -137,11 +145,46 @@ type File struct {
 	// The Client’s TCP port or zero in case the client has low ID.
 	Port uint16
 	// File tags: name, size, type, etc.
-	Tags []Tag
+	Tags Tags
 }
 
-// ReadFile reads structured binary data from r and parses the data to file.
+// Size returns the file size from the FT_FILESIZE and FT_FILESIZE_HI tags.
+// FT_FILESIZE may be a 32-bit or 64-bit integer tag, FT_FILESIZE_HI holds the high 32 bits of a large file size.
+func (f *File) Size() uint64 {
+	size, _ := f.Tags.Uint64(FTFileSize)
+	if hi, ok := f.Tags.Uint64(FTFileSizeHi); ok {
+		size = size&math.MaxUint32 | hi<<32
+	}
+	return size
+}
+
+// SetSize sets the file size tags in the form supported by the peer with the capability flags.
+// A large file (> 4GB) is sent as uint64 tag if flags has CapLargeFiles, or as FT_FILESIZE and FT_FILESIZE_HI otherwise.
+func (f *File) SetSize(size uint64, flags uint32) error {
+	if size > MaxFileSize {
+		return ErrFileTooLarge
+	}
+	f.Tags.Delete(FTFileSizeHi)
+	if size <= math.MaxUint32 {
+		f.Tags.Set(Uint32Tag(FTFileSize, uint32(size)))
+		return nil
+	}
+	if flags&CapLargeFiles != 0 {
+		f.Tags.Set(Uint64Tag(FTFileSize, size))
+		return nil
+	}
+	f.Tags.Set(Uint32Tag(FTFileSize, uint32(size)))
+	f.Tags.Set(Uint32Tag(FTFileSizeHi, uint32(size>>32)))
+	return nil
+}
+
+// ReadFile reads structured binary data from r and parses the data to file using DefaultTagDecoder.
 func ReadFile(r io.Reader) (*File, error) {
+	return DefaultTagDecoder.ReadFile(r)
+}
+
+// ReadFile reads structured binary data from r and parses the data to file.
+func (d *TagDecoder) ReadFile(r io.Reader) (*File, error) {
 	if r == nil {
 		return nil, io.EOF
 	}
@@ -157,7 +200,7 @@ func ReadFile(r io.Reader) (*File, error) {
 	file.Port = binary.LittleEndian.Uint16(b[pos : pos+2])
 	pos += 2
 	tagCount := binary.LittleEndian.Uint32(b[pos : pos+4])
-	if err := file.readTag(r, int(tagCount)); err != nil {
+	if err := file.readTag(r, int(tagCount), d); err != nil {
 		return nil, err
 	}
 	return file, nil
@@ -189,17 +232,20 @@ func (f *File) Decode(data []byte) (err error) {
 	pos += 2
 	count := binary.LittleEndian.Uint32(data[pos : pos+4])
 	pos += 4
-	err = f.readTag(bytes.NewReader(data[pos:]), int(count))
+	err = f.readTag(bytes.NewReader(data[pos:]), int(count), DefaultTagDecoder)
 	return
 }
 
-func (f *File) readTag(r io.Reader, count int) error {
+func (f *File) readTag(r io.Reader, count int, d *TagDecoder) error {
 	for i := 0; i < count; i++ {
-		tag, err := ReadTag(r)
+		tag, err := d.ReadTag(r)
 		if err != nil {
 			return err
 		}
 		f.Tags = append(f.Tags, tag)
+		if isRawTag(tag) {
+			break
+		}
 	}
 	return nil
 }
@@ -238,37 +284,3 @@ func (f *File) WriteTo(w io.Writer) (n int64, err error) {
 
 	return
 }
-
-// FileSearcher is a file search struct.
-type FileSearcher interface {
-	Encode() (data []byte, err error)
-}
-
-type fileNameSearcher struct {
-	Name string
-}
-
-func (s *fileNameSearcher) Encode() (data []byte, err error) {
-	if s == nil || s.Name == "" {
-		err = errors.New("empty searcher")
-		return
-	}
-	data = make([]byte, 3+len(s.Name))
-	data[0] = SearchName
-	binary.LittleEndian.PutUint16(data[1:3], uint16(len(s.Name)))
-	copy(data[3:], s.Name)
-
-	return
-}
-
-func (s *fileNameSearcher) String() string {
-	if s == nil {
-		return ""
-	}
-	return fmt.Sprintf("by name: %s", s.Name)
-}
-
-// FileNameSearcher is a file searcher by file name.
-func FileNameSearcher(name string) FileSearcher {
-	return &fileNameSearcher{Name: name}
-}
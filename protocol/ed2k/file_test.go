@@ -1,6 +1,7 @@
 package ed2k
 
 import (
+	"math"
 	"os"
 	"testing"
 )
@@ -18,3 +19,49 @@ func TestHash(t *testing.T) {
 	}
 	t.Log(hash)
 }
+
+func TestFileSize(t *testing.T) {
+	testCases := []struct {
+		size  uint64
+		flags uint32
+		tags  int
+	}{
+		{0, 0, 1},
+		{700 << 20, CapLargeFiles, 1},
+		{math.MaxUint32, 0, 1},
+		{5 << 30, CapLargeFiles, 1},
+		{5 << 30, 0, 2},
+		{MaxFileSize, 0, 2},
+	}
+
+	for i, tc := range testCases {
+		f := &File{Tags: Tags{StringTag(FTFileName, "a", false)}}
+		if err := f.SetSize(tc.size, tc.flags); err != nil {
+			t.Fatal(i, err)
+		}
+		if len(f.Tags) != tc.tags+1 {
+			t.Errorf("%d: got %d tags, want %d", i, len(f.Tags)-1, tc.tags)
+		}
+		data, err := f.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		f2 := &File{}
+		if err := f2.Decode(data); err != nil {
+			t.Fatal(i, err)
+		}
+		if size := f2.Size(); size != tc.size {
+			t.Errorf("%d: got size %d, want %d", i, size, tc.size)
+		}
+	}
+
+	f := &File{}
+	f.SetSize(5<<30, 0)
+	f.SetSize(1024, CapLargeFiles)
+	if f.Tags.Has(FTFileSizeHi) || f.Size() != 1024 {
+		t.Errorf("stale FT_FILESIZE_HI tag: %v", f.Tags)
+	}
+	if err := f.SetSize(MaxFileSize+1, CapLargeFiles); err != ErrFileTooLarge {
+		t.Errorf("got %v, want %v", err, ErrFileTooLarge)
+	}
+}
@@ -0,0 +1,100 @@
+package ed2k
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// fullFrame reports whether data holds a whole message frame of class, with a valid header.
+func fullFrame(class int, data []byte) bool {
+	if class == CSUDPMessage || class == CCUDPMessage {
+		return true
+	}
+	if len(data) < HeaderLength {
+		return false
+	}
+	switch data[0] {
+	case 0, ProtoEDonkey, ProtoEMule, ProtoPacked:
+	default:
+		return false
+	}
+	size := int64(binary.LittleEndian.Uint32(data[1:5]))
+	return size > 0 && int64(len(data)) >= HeaderLength+size
+}
+
+func FuzzReadMessage(f *testing.F) {
+	hash := make([]byte, 16)
+	for _, seed := range [][]byte{
+		tcpMessage(ProtoEDonkey, MessageLoginRequest, append(hash, 0, 0, 0, 0, 0x36, 0x12, 0, 0, 0, 0)...),
+		tcpMessage(ProtoEDonkey, MessageHello, append(append([]byte{16}, hash...), 0, 0, 0, 0, 0x36, 0x12, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0)...),
+		tcpMessage(ProtoEDonkey, MessageSearchResult, 1, 0, 0, 0),
+		tcpMessage(ProtoEDonkey, MessageServerIdent, append(hash, 0, 0, 0, 0, 0, 0, 1, 0, 0, 0)...),
+		tcpMessage(ProtoEDonkey, MessageFoundSourcesOBFU, append(hash, 1, 1, 2, 3, 4, 0x36, 0x12, SourceUserHash)...),
+		tcpMessage(ProtoEMule, MessageMultiPacket, append(hash, MessageFileRequest, 10, 0, 0x81, 0x02, 5, 0)...),
+		tcpMessage(ProtoEMule, MessageAnswerSources2, append(append([]byte{4}, hash...), 1, 0)...),
+		{ProtoEDonkey, MessageGlobalSearchResult},
+		{ProtoEDonkey, MessageServerDescResponse, 0xFF, 0xF0, 0x34, 0x12, 2, 0, 0, 0},
+	} {
+		for _, class := range []uint8{CSTCPMessage, CSUDPMessage, CCTCPMessage, CCUDPMessage} {
+			f.Add(class, seed)
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, class uint8, data []byte) {
+		m, err := ReadMessage(bytes.NewReader(data), int(class%4))
+		if err != nil {
+			// a malformed message is reported as decode error once the whole frame is read.
+			var de *DecodeError
+			if fullFrame(int(class%4), data) && !errors.As(err, &de) {
+				t.Fatalf("got %v, want decode error", err)
+			}
+			return
+		}
+		// a decoded message must encode.
+		m.Encode()
+		_ = m.String()
+	})
+}
+
+func FuzzReadTag(f *testing.F) {
+	for _, seed := range [][]byte{
+		{TagString, 1, 0, TagName, 3, 0, 'a', 'b', 'c'},
+		{TagStr1 | 0x80, TagName, 'a'},
+		{TagUint64 | 0x80, TagSize, 1, 2, 3, 4, 5, 6, 7, 8},
+		{TagBlob | 0x80, TagName, 2, 0, 0, 0, 1, 2},
+		{TagBsob | 0x80, TagName, 2, 1, 2},
+		{TagHash16 | 0x80, TagName, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16},
+		{TagBoolArray, 1, 0, TagName, 9, 0, 1, 2},
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		tag, err := ReadTag(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		tag.Encode()
+	})
+}
+
+func FuzzReadFile(f *testing.F) {
+	f.Add([]byte{
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16,
+		1, 2, 3, 4, 0x36, 0x12, 1, 0, 0, 0,
+		TagUint32 | 0x80, TagSize, 0, 0, 0xC0, 0x2B,
+	})
+	f.Add(make([]byte, 26))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		file, err := ReadFile(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		file.Size()
+		file.Encode()
+		(&File{}).Decode(data)
+	})
+}
@@ -0,0 +1,171 @@
+package ed2k
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+const (
+	// DefaultLoginFlags is the server capabilities announced by the client in the login request by default.
+	DefaultLoginFlags = CapZlib | CapNewTag | CapUnicode | CapLargeFiles
+)
+
+// LoginOptions are the client settings sent to the server in the login request.
+type LoginOptions struct {
+	UID UID
+	// The client ID, usually 0.
+	ClientID ClientID
+	// The TCP port used by the client.
+	Port uint16
+	// The user nick name.
+	Name string
+	// The eDonkey version, EDonkeyVerion if 0.
+	Version uint32
+	// The server capabilities supported by the client, Cap* flags, DefaultLoginFlags if 0.
+	Flags uint32
+	// The eMule version, EMuleVersion if 0.
+	EMuleVersion uint32
+}
+
+// Tags returns the login request tags of the options.
+func (o *LoginOptions) Tags() Tags {
+	version := o.Version
+	if version == 0 {
+		version = EDonkeyVerion
+	}
+	flags := o.Flags
+	if flags == 0 {
+		flags = DefaultLoginFlags
+	}
+	emuleVersion := o.EMuleVersion
+	if emuleVersion == 0 {
+		emuleVersion = EMuleVersion
+	}
+	return Tags{
+		StringTag(CTName, o.Name, false),
+		Uint32Tag(CTVersion, version),
+		Uint32Tag(CTPort, uint32(o.Port)),
+		Uint32Tag(CTServerFlags, flags),
+		Uint32Tag(CTEMuleVersion, emuleVersion),
+	}
+}
+
+// NewLoginMessage creates the login request message of the options.
+func NewLoginMessage(o LoginOptions) *LoginMessage {
+	return &LoginMessage{
+		UID:      o.UID,
+		ClientID: o.ClientID,
+		Port:     o.Port,
+		Tags:     o.Tags(),
+	}
+}
+
+// ParseLoginOptions reads the options from the login request message m.
+// The port is read from the port tag if the message port is 0.
+func ParseLoginOptions(m *LoginMessage) LoginOptions {
+	o := LoginOptions{
+		UID:      m.UID,
+		ClientID: m.ClientID,
+		Port:     m.Port,
+	}
+	o.Name, _ = m.Tags.String(CTName)
+	o.Version, _ = m.Tags.Uint32(CTVersion)
+	o.Flags, _ = m.Tags.Uint32(CTServerFlags)
+	o.EMuleVersion, _ = m.Tags.Uint32(CTEMuleVersion)
+	if port, ok := m.Tags.Uint32(CTPort); ok && o.Port == 0 {
+		o.Port = uint16(port)
+	}
+	return o
+}
+
+// Server TCP flags, sent by the server in the ID change message.
+const (
+	ServerFlagCompression    = 0x0001
+	ServerFlagNewTags        = 0x0008
+	ServerFlagUnicode        = 0x0010
+	ServerFlagRelatedSearch  = 0x0040
+	ServerFlagTypeTagInteger = 0x0080
+	ServerFlagLargeFiles     = 0x0100
+	ServerFlagObfuscation    = 0x0400
+)
+
+// ServerCapabilities is the server TCP flags, ServerFlag* bits.
+type ServerCapabilities uint32
+
+// Compression reports whether the server supports compressed (packed) messages.
+func (c ServerCapabilities) Compression() bool {
+	return c&ServerFlagCompression != 0
+}
+
+// NewTags reports whether the server supports the compact tags.
+func (c ServerCapabilities) NewTags() bool {
+	return c&ServerFlagNewTags != 0
+}
+
+// Unicode reports whether the server supports UTF-8 strings.
+func (c ServerCapabilities) Unicode() bool {
+	return c&ServerFlagUnicode != 0
+}
+
+// LargeFiles reports whether the server supports files larger than 4GB.
+func (c ServerCapabilities) LargeFiles() bool {
+	return c&ServerFlagLargeFiles != 0
+}
+
+// Obfuscation reports whether the server supports obfuscated TCP connections.
+func (c ServerCapabilities) Obfuscation() bool {
+	return c&ServerFlagObfuscation != 0
+}
+
+// Flags returns the capability flags (Cap*) used by the encoders, e.g. Conn.SetFlags and File.SetSize.
+func (c ServerCapabilities) Flags() (flags uint32) {
+	if c.Compression() {
+		flags |= CapZlib
+	}
+	if c.NewTags() {
+		flags |= CapNewTag
+	}
+	if c.Unicode() {
+		flags |= CapUnicode
+	}
+	if c.LargeFiles() {
+		flags |= CapLargeFiles
+	}
+	if c.Obfuscation() {
+		flags |= CapSupportCrypt
+	}
+	return
+}
+
+func (c ServerCapabilities) String() string {
+	names := []struct {
+		flag uint32
+		name string
+	}{
+		{ServerFlagCompression, "compression"},
+		{ServerFlagNewTags, "newtags"},
+		{ServerFlagUnicode, "unicode"},
+		{ServerFlagRelatedSearch, "related-search"},
+		{ServerFlagTypeTagInteger, "type-tag-integer"},
+		{ServerFlagLargeFiles, "largefiles"},
+		{ServerFlagObfuscation, "obfuscation"},
+	}
+	var ss []string
+	for _, n := range names {
+		if uint32(c)&n.flag != 0 {
+			ss = append(ss, n.name)
+		}
+	}
+	b := bytes.Buffer{}
+	fmt.Fprintf(&b, "%#x", uint32(c))
+	if len(ss) > 0 {
+		fmt.Fprintf(&b, " (%s)", strings.Join(ss, ","))
+	}
+	return b.String()
+}
+
+// Capabilities returns the server capabilities of the bitmap.
+func (m *IDChangeMessage) Capabilities() ServerCapabilities {
+	return ServerCapabilities(m.Bitmap)
+}
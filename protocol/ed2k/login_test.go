@@ -0,0 +1,56 @@
+package ed2k
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestLoginOptions(t *testing.T) {
+	o := LoginOptions{
+		UID:   NewUID(),
+		Port:  4662,
+		Name:  "gmule",
+		Flags: DefaultLoginFlags | CapSupportCrypt,
+	}
+	data, err := NewLoginMessage(o).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(data, []byte{TagInteger, 1, 0, TagEMuleVersion}) {
+		t.Errorf("no eMule version tag: %# x", data)
+	}
+
+	m := &LoginMessage{}
+	if err := m.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	o.Version, o.EMuleVersion = EDonkeyVerion, EMuleVersion
+	if got := ParseLoginOptions(m); got != o {
+		t.Errorf("got %+v, want %+v", got, o)
+	}
+
+	// zero options announce the default capabilities.
+	if flags, _ := (&LoginOptions{}).Tags().Uint32(CTServerFlags); flags != DefaultLoginFlags {
+		t.Errorf("default flags %#x", flags)
+	}
+}
+
+func TestServerCapabilities(t *testing.T) {
+	m := &IDChangeMessage{}
+	if err := m.Decode([]byte{ProtoEDonkey, 9, 0, 0, 0, MessageIDChange, 1, 2, 3, 4, 0x19, 0x05, 0, 0}); err != nil {
+		t.Fatal(err)
+	}
+	c := m.Capabilities()
+	if !c.Compression() || !c.NewTags() || !c.Unicode() || !c.LargeFiles() || !c.Obfuscation() {
+		t.Errorf("capabilities %s", c)
+	}
+	if flags := c.Flags(); flags != CapZlib|CapNewTag|CapUnicode|CapLargeFiles|CapSupportCrypt {
+		t.Errorf("flags %#x", flags)
+	}
+	if s := c.String(); s != "0x519 (compression,newtags,unicode,largefiles,obfuscation)" {
+		t.Errorf("string %s", s)
+	}
+	if c := ServerCapabilities(0); c.Compression() || c.Flags() != 0 || c.String() != "0x0" {
+		t.Errorf("capabilities %s", c)
+	}
+}
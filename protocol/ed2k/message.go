@@ -38,43 +38,108 @@ const (
 	MessageFoundSourcesOBFU  = 0x44
 )
 
+// Client-Server UDP messages.
+const (
+	MessageGlobalSearchRequest3       = 0x90
+	MessageGlobalSearchRequest2       = 0x92
+	MessageGlobalGetSources2          = 0x94
+	MessageGlobalServerStatusRequest  = 0x96
+	MessageGlobalServerStatusResponse = 0x97
+	MessageGlobalSearchRequest        = 0x98
+	MessageGlobalSearchResult         = 0x99
+	MessageGlobalGetSources           = 0x9A
+	MessageGlobalFoundSources         = 0x9B
+	MessageServerDescRequest          = 0xA2
+	MessageServerDescResponse         = 0xA3
+)
+
 // Client-Client TCP messages.
 const (
-	MessageHello       = 0x01
-	MessageHelloAnswer = 0x02
+	MessageHello              = 0x01
+	MessageSendingPart        = 0x46
+	MessageRequestParts       = 0x47
+	MessageNoSuchFile         = 0x48
+	MessageEndOfDownload      = 0x49
+	MessageHelloAnswer        = 0x4C
+	MessageSetRequestedFileID = 0x4F
+	MessageFileStatus         = 0x50
+	MessageHashSetRequest     = 0x51
+	MessageHashSetAnswer      = 0x52
+	MessageStartUploadRequest = 0x54
+	MessageAcceptUpload       = 0x55
+	MessageCancelTransfer     = 0x56
+	MessageOutOfParts         = 0x57
+	MessageFileRequest        = 0x58
+	MessageFileRequestAnswer  = 0x59
+	MessageQueueRank          = 0x5C
+)
+
+// Client-Client eMule TCP messages, sent with protocol ProtoEMule.
+const (
+	MessageEMuleInfo             = 0x01
+	MessageEMuleInfoAnswer       = 0x02
+	MessageCompressedPart        = 0x40
+	MessageQueueRanking          = 0x60
+	MessageRequestSources        = 0x81
+	MessageAnswerSources         = 0x82
+	MessageRequestSources2       = 0x83
+	MessageAnswerSources2        = 0x84
+	MessageMultiPacket           = 0x92
+	MessageMultiPacketAnswer     = 0x93
+	MessagePublicIPRequest       = 0x97
+	MessagePublicIPAnswer        = 0x98
+	MessageAICHRequest           = 0x9B
+	MessageAICHAnswer            = 0x9C
+	MessageAICHFileHashAnswer    = 0x9D
+	MessageAICHFileHashRequest   = 0x9E
+	MessageCompressedPartI64     = 0xA1
+	MessageSendingPartI64        = 0xA2
+	MessageRequestPartsI64       = 0xA3
+	MessageMultiPacketExt        = 0xA4
+	MessageMultiPacketExt2       = 0xA9
+	MessageMultiPacketAnswerExt2 = 0xB0
 )
 
 // errors
 var (
 	ErrShortBuffer      = io.ErrShortBuffer
 	ErrWrongMessageType = errors.New("wrong message type")
+	ErrMessageTooLarge  = errors.New("message too large")
 )
 
-var (
-	mCSTCPMessages = map[uint8]func() Message{
-		MessageLoginRequest:      func() Message { return &LoginMessage{} },
-		MessageServerMessage:     func() Message { return &ServerMessage{} },
-		MessageIDChange:          func() Message { return &IDChangeMessage{} },
-		MessageOfferFiles:        func() Message { return &OfferFilesMessage{} },
-		MessageGetServerList:     func() Message { return &GetServerListMessage{} },
-		MessageServerList:        func() Message { return &ServerListMessage{} },
-		MessageServerStatus:      func() Message { return &ServerStatusMessage{} },
-		MessageServerIdent:       func() Message { return &ServerIdentMessage{} },
-		MessageSearchRequest:     func() Message { return &SearchRequestMessage{} },
-		MessageSearchResult:      func() Message { return &SearchResultMessage{} },
-		MessageGetSources:        func() Message { return &GetSourcesMessage{} },
-		MessageFoundSources:      func() Message { return &FoundSourcesMessage{} },
-		MessageCallbackRequest:   func() Message { return &CallbackRequestMessage{} },
-		MessageCallbackRequested: func() Message { return &CallbackRequestedMessage{} },
-		MessageCallbackFailed:    func() Message { return &CallbackFailedMessage{} },
-		MessageRejected:          func() Message { return &RejectedMessage{} },
-	}
+// DecodeError is returned by the message decoders for a malformed message.
+type DecodeError struct {
+	// The type of the message being decoded.
+	Type uint8
+	// The offset in the message data where decoding failed.
+	Offset int
+	// The field or value being decoded.
+	Reason string
+	// The underlying error, such as ErrShortBuffer or ErrWrongMessageType.
+	Err error
+}
 
-	mCCTCPMessages = map[uint8]func() Message{
-		MessageHello:       func() Message { return &HelloMessage{} },
-		MessageHelloAnswer: func() Message { return &HelloAnswerMessage{} },
+func (e *DecodeError) Error() string {
+	return fmt.Sprintf("decode message %#x at offset %d: %s: %v", e.Type, e.Offset, e.Reason, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *DecodeError) Unwrap() error {
+	return e.Err
+}
+
+// decodeError returns a *DecodeError of the message type mType failed at offset, err is returned unchanged
+// if it is already a *DecodeError.
+func decodeError(mType uint8, offset int, reason string, err error) error {
+	if _, ok := err.(*DecodeError); ok {
+		return err
 	}
-)
+	// the message data is complete, reading past its end is a short buffer.
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		err = ErrShortBuffer
+	}
+	return &DecodeError{Type: mType, Offset: offset, Reason: reason, Err: err}
+}
 
 // UID is user ID, it is a 128 bit (16 byte) GUID.
 // the 6th and 15th (start from 1st) bytes values are 14 and 111 respectively.
@@ -114,6 +179,14 @@ func (uid UID) String() string {
 // A low ID is always lower than 16777216 (0x1000000).
 type ClientID uint32
 
+// LowIDLimit is the upper bound (exclusive) of low IDs.
+const LowIDLimit = 0x1000000
+
+// LowID reports whether the client ID is a low ID.
+func (cid ClientID) LowID() bool {
+	return cid < LowIDLimit
+}
+
 func (cid ClientID) String() string {
 	return net.IPv4(uint8(cid&0xFF), uint8((cid>>8)&0xFF), uint8((cid>>16)&0xFF), uint8((cid>>24)&0xFF)).String()
 }
@@ -131,10 +204,108 @@ type message struct {
 	Header Header
 }
 
+// tagMessage is implemented by the messages with tags, decode decodes the message with the tags decoded by d.
+type tagMessage interface {
+	decode(data []byte, d *TagDecoder) error
+}
+
+// decodeMessage decodes m from data with the tags decoded by d, DefaultTagDecoder is used if d is nil.
+func decodeMessage(m Message, data []byte, d *TagDecoder) error {
+	if tm, ok := m.(tagMessage); ok && d != nil {
+		return tm.decode(data, d)
+	}
+	return m.Decode(data)
+}
+
 func (m *message) Protocol() uint8 {
 	return m.Header.Protocol
 }
 
+// writeMessageHeader writes the header h and the message type to buf, the size is set by messageData.
+func writeMessageHeader(buf *bytes.Buffer, h Header, mType uint8) error {
+	if _, err := h.WriteTo(buf); err != nil {
+		return err
+	}
+	return buf.WriteByte(mType)
+}
+
+// messageData returns the message encoded in buf with the message size set in the header.
+func messageData(buf *bytes.Buffer) []byte {
+	data := buf.Bytes()
+	binary.LittleEndian.PutUint32(data[1:5], uint32(len(data)-HeaderLength)) // message size
+	return data
+}
+
+// decodeMessageHeader checks that data is a message of mType with a payload of at least min bytes
+// following the message type. The return value end is the end of the message in data.
+func decodeMessageHeader(data []byte, mType uint8, min int) (header Header, end int, err error) {
+	if err = header.Decode(data); err != nil {
+		err = decodeError(mType, 0, "header", err)
+		return
+	}
+	end = HeaderLength + int(header.Size)
+	if len(data) < end {
+		err = decodeError(mType, len(data), "message size", ErrShortBuffer)
+		return
+	}
+	if int(header.Size) < 1+min {
+		err = decodeError(mType, end, "message payload", ErrShortBuffer)
+		return
+	}
+	if data[HeaderLength] != mType {
+		err = decodeError(mType, HeaderLength, fmt.Sprintf("message type %#x", data[HeaderLength]), ErrWrongMessageType)
+	}
+	return
+}
+
+// readTags reads count tags of the message of mType from data[pos:end] with the TagDecoder d,
+// the return value n is the position following the tags. The count is not trusted, reading stops at end.
+// A raw tag of unknown type holds the rest of the data and ends the tag list.
+func readTags(d *TagDecoder, data []byte, pos, end int, count uint32, mType uint8) (tags Tags, n int, err error) {
+	if d == nil {
+		d = DefaultTagDecoder
+	}
+	r := bytes.NewReader(data[pos:end])
+	for i := uint32(0); i < count; i++ {
+		off := end - r.Len()
+		tag, er := d.ReadTag(r)
+		if er != nil {
+			err = decodeError(mType, off, fmt.Sprintf("tag %d", i), er)
+			return
+		}
+		tags = append(tags, tag)
+		if isRawTag(tag) {
+			break
+		}
+	}
+	n = end - r.Len()
+	return
+}
+
+// readFiles reads count files of the message of mType from data[pos:end] with the TagDecoder d,
+// the return value n is the position following the files. The count is not trusted, reading stops at end.
+// A file ending with a raw tag of unknown type ends the file list.
+func readFiles(d *TagDecoder, data []byte, pos, end int, count uint32, mType uint8) (files []File, n int, err error) {
+	if d == nil {
+		d = DefaultTagDecoder
+	}
+	r := bytes.NewReader(data[pos:end])
+	for i := uint32(0); i < count; i++ {
+		off := end - r.Len()
+		file, er := d.ReadFile(r)
+		if er != nil {
+			err = decodeError(mType, off, fmt.Sprintf("file %d", i), er)
+			return
+		}
+		files = append(files, *file)
+		if len(file.Tags) > 0 && isRawTag(file.Tags[len(file.Tags)-1]) {
+			break
+		}
+	}
+	n = end - r.Len()
+	return
+}
+
 // NullMessage is a message that it is size field of header is 0.
 type NullMessage struct {
 	message
@@ -186,7 +357,22 @@ const (
 )
 
 // ReadMessage reads structured binary data from r and parses the data to message.
+// The message type is looked up in the message registry, unknown opcodes are read as RawMessage.
 func ReadMessage(r io.Reader, class int) (m Message, err error) {
+	m, _, err = readMessage(r, class, 0, nil)
+	return
+}
+
+// ReadMessage reads structured binary data from r and parses the data to message, the message tags are decoded by d.
+func (d *TagDecoder) ReadMessage(r io.Reader, class int) (m Message, err error) {
+	m, _, err = readMessage(r, class, 0, d)
+	return
+}
+
+// readMessage reads a message of class from r, the message size must not be larger than maxSize
+// if maxSize is greater than 0. The message tags are decoded by d, DefaultTagDecoder if d is nil.
+// The return value n is the number of bytes read.
+func readMessage(r io.Reader, class int, maxSize int, d *TagDecoder) (m Message, n int, err error) {
 	switch class {
 	case CSTCPMessage, CCTCPMessage:
 		data := make([]byte, 256)
@@ -194,41 +380,60 @@ func ReadMessage(r io.Reader, class int) (m Message, err error) {
 		if _, err = io.ReadFull(r, data[:HeaderLength]); err != nil {
 			return
 		}
+		n = HeaderLength
 		header := Header{}
 		if err = header.Decode(data[:HeaderLength]); err != nil {
 			return
 		}
 
 		if header.Size == 0 {
-			return &NullMessage{message: message{Header: header}}, nil
+			return &NullMessage{message: message{Header: header}}, n, nil
 		}
-
-		mSize := HeaderLength + int(header.Size)
-		if len(data) < mSize {
-			b := data
-			data = make([]byte, mSize)
-			copy(data[:], b[:HeaderLength])
+		if maxSize > 0 && int64(header.Size) > int64(maxSize) {
+			err = ErrMessageTooLarge
+			return
 		}
-		if _, err = io.ReadFull(r, data[HeaderLength:mSize]); err != nil {
+
+		// the body is read as it arrives rather than allocated from the untrusted size,
+		// a peer can't make us allocate a large buffer it never sends.
+		buf := bytes.NewBuffer(data[:HeaderLength])
+		nn, er := io.CopyN(buf, r, int64(header.Size))
+		n += int(nn)
+		if er != nil {
+			if er == io.EOF && nn > 0 {
+				er = io.ErrUnexpectedEOF
+			}
+			err = er
 			return
 		}
+		data = buf.Bytes()
+
+		// packed messages are inflated transparently, server messages are
+		// eDonkey messages and client messages are eMule messages once unpacked.
+		// the message is looked up by the header protocol, a zero protocol byte is read as eDonkey.
+		proto, mType := header.Protocol, data[5]
+		if proto == ProtoPacked {
+			proto, _ = unpackedProto(class)
+			if data, err = unpack(data, proto, MaxUnpackedSize); err != nil {
+				err = decodeError(mType, HeaderLength+1, "packed payload", err)
+				return
+			}
+		}
 
-		var fn func() Message
-		var ok bool
-		mType := data[5]
-		if class == CSTCPMessage {
-			fn, ok = mCSTCPMessages[mType]
-		} else {
-			fn, ok = mCCTCPMessages[mType]
+		m = NewMessage(class, proto, mType)
+		err = decodeMessage(m, data, d)
+	case CSUDPMessage, CCUDPMessage:
+		// UDP messages have no size field, r holds a single datagram.
+		var data []byte
+		if data, err = io.ReadAll(r); err != nil {
+			return
 		}
-		if !ok {
-			err = fmt.Errorf("unknown message type: %v", mType)
+		n = len(data)
+		if maxSize > 0 && n > maxSize {
+			err = ErrMessageTooLarge
 			return
 		}
-		m = fn()
-		err = m.Decode(data)
-	case CSUDPMessage:
-	case CCUDPMessage:
+		m, err = decodeDatagram(data, class, d)
 	default:
 		err = errors.New("unknown message class")
 	}
@@ -0,0 +1,1368 @@
+// Client Client eMule extended TCP Messages
+
+package ed2k
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+)
+
+const (
+	// EMuleProtocolVersion is the eMule protocol version sent in the eMule info message.
+	EMuleProtocolVersion = 0x01
+	// SourceExchangeVersion is the latest source exchange version supported.
+	SourceExchangeVersion = 4
+	// DefaultExtRequestsVersion is the extended requests version assumed to decode the multipacket
+	// file name requests, it is the version announced by eMule.
+	DefaultExtRequestsVersion = 2
+)
+
+// errors
+var (
+	ErrMultiPacketOpcode = errors.New("unknown multipacket opcode")
+	ErrFileIdentifier    = errors.New("invalid file identifier")
+)
+
+func init() {
+	for opcode, fn := range map[uint8]func() Message{
+		MessageEMuleInfo:             func() Message { return &EMuleInfoMessage{} },
+		MessageEMuleInfoAnswer:       func() Message { return &EMuleInfoAnswerMessage{} },
+		MessageCompressedPart:        func() Message { return &CompressedPartMessage{} },
+		MessageCompressedPartI64:     func() Message { return &CompressedPartI64Message{} },
+		MessageQueueRanking:          func() Message { return &QueueRankingMessage{} },
+		MessageRequestSources:        func() Message { return &RequestSourcesMessage{} },
+		MessageAnswerSources:         func() Message { return &AnswerSourcesMessage{} },
+		MessageRequestSources2:       func() Message { return &RequestSources2Message{} },
+		MessageAnswerSources2:        func() Message { return &AnswerSources2Message{} },
+		MessageMultiPacket:           func() Message { return &MultiPacketMessage{Version: 1, ExtRequestsVersion: DefaultExtRequestsVersion} },
+		MessageMultiPacketExt:        func() Message { return &MultiPacketMessage{Version: 2, ExtRequestsVersion: DefaultExtRequestsVersion} },
+		MessageMultiPacketExt2:       func() Message { return &MultiPacketMessage{Version: 3, ExtRequestsVersion: DefaultExtRequestsVersion} },
+		MessageMultiPacketAnswer:     func() Message { return &MultiPacketAnswerMessage{} },
+		MessageMultiPacketAnswerExt2: func() Message { return &MultiPacketAnswerMessage{Ext2: true} },
+		MessageRequestPartsI64:       func() Message { return &RequestPartsI64Message{} },
+		MessageSendingPartI64:        func() Message { return &SendingPartI64Message{} },
+		MessagePublicIPRequest:       func() Message { return &PublicIPRequestMessage{} },
+		MessagePublicIPAnswer:        func() Message { return &PublicIPAnswerMessage{} },
+		MessageAICHRequest:           func() Message { return &AICHRequestMessage{} },
+		MessageAICHAnswer:            func() Message { return &AICHAnswerMessage{} },
+		MessageAICHFileHashRequest:   func() Message { return &AICHFileHashRequestMessage{} },
+		MessageAICHFileHashAnswer:    func() Message { return &AICHFileHashAnswerMessage{} },
+	} {
+		RegisterMessage(CCTCPMessage, ProtoEMule, opcode, fn)
+	}
+}
+
+// emuleHeader returns the header h with protocol ProtoEMule if it is not set.
+func emuleHeader(h Header) Header {
+	if h.Protocol == 0 {
+		h.Protocol = ProtoEMule
+	}
+	return h
+}
+
+// EMuleInfoMessage message is sent after the hello handshake by old eMule clients to exchange the eMule capabilities,
+// newer clients send the capabilities in the hello tags.
+type EMuleInfoMessage struct {
+	message
+	// The eMule client version.
+	ClientVersion uint8
+	// The eMule protocol version, EMuleProtocolVersion.
+	ProtocolVersion uint8
+	Tags            Tags
+}
+
+// Encode encodes the message to binary data.
+func (m *EMuleInfoMessage) Encode() (data []byte, err error) {
+	return m.encode(MessageEMuleInfo)
+}
+
+func (m *EMuleInfoMessage) encode(mType uint8) (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), mType); err != nil {
+		return
+	}
+	buf.WriteByte(m.ClientVersion)
+	buf.WriteByte(m.ProtocolVersion)
+	binary.Write(buf, binary.LittleEndian, uint32(len(m.Tags)))
+	for _, tag := range m.Tags {
+		if _, err = tag.WriteTo(buf); err != nil {
+			return
+		}
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *EMuleInfoMessage) Decode(data []byte) (err error) {
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *EMuleInfoMessage) decode(data []byte, d *TagDecoder) (err error) {
+	return m.decodeInfo(data, MessageEMuleInfo, d)
+}
+
+func (m *EMuleInfoMessage) decodeInfo(data []byte, mType uint8, d *TagDecoder) (err error) {
+	header, end, err := decodeMessageHeader(data, mType, 1+1+4)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	m.ClientVersion = data[pos]
+	m.ProtocolVersion = data[pos+1]
+	pos += 2
+	count := binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
+	if m.Tags, _, err = readTags(d, data, pos, end, count, mType); err != nil {
+		return
+	}
+	m.Header = header
+	return
+}
+
+// Type is the message type.
+func (m EMuleInfoMessage) Type() uint8 {
+	return MessageEMuleInfo
+}
+
+func (m EMuleInfoMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[emule-info]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "version: %d, protocol: %d\n", m.ClientVersion, m.ProtocolVersion)
+	for i, tag := range m.Tags {
+		fmt.Fprintf(&b, "tag%d - %v: %v\n", i, tag.Name(), tag.Value())
+	}
+	return b.String()
+}
+
+// EMuleInfoAnswerMessage message is sent as an answer to EMuleInfoMessage.
+type EMuleInfoAnswerMessage struct {
+	EMuleInfoMessage
+}
+
+// Encode encodes the message to binary data.
+func (m *EMuleInfoAnswerMessage) Encode() (data []byte, err error) {
+	return m.encode(MessageEMuleInfoAnswer)
+}
+
+// Decode decodes the message from binary data.
+func (m *EMuleInfoAnswerMessage) Decode(data []byte) (err error) {
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *EMuleInfoAnswerMessage) decode(data []byte, d *TagDecoder) (err error) {
+	return m.decodeInfo(data, MessageEMuleInfoAnswer, d)
+}
+
+// Type is the message type.
+func (m EMuleInfoAnswerMessage) Type() uint8 {
+	return MessageEMuleInfoAnswer
+}
+
+func (m EMuleInfoAnswerMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[emule-info-answer]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "version: %d, protocol: %d\n", m.ClientVersion, m.ProtocolVersion)
+	for i, tag := range m.Tags {
+		fmt.Fprintf(&b, "tag%d - %v: %v\n", i, tag.Name(), tag.Value())
+	}
+	return b.String()
+}
+
+// CompressedPartMessage message is sent by the uploading client instead of SendingPartMessage
+// when both clients support compression. A requested range is compressed with zlib as a whole,
+// and the compressed stream is sent in several messages.
+type CompressedPartMessage struct {
+	message
+	Hash [16]byte
+	// The start of the uncompressed range.
+	Start uint64
+	// The total size of the compressed range.
+	PackedSize uint32
+	// A block of the compressed range.
+	Data []byte
+}
+
+// Encode encodes the message to binary data, the offset must fit in 32 bits.
+func (m *CompressedPartMessage) Encode() (data []byte, err error) {
+	return m.encode(MessageCompressedPart, false)
+}
+
+func (m *CompressedPartMessage) encode(mType uint8, large bool) (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), mType); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+	if err = writeOffset(buf, m.Start, large); err != nil {
+		return
+	}
+	binary.Write(buf, binary.LittleEndian, m.PackedSize)
+	buf.Write(m.Data)
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *CompressedPartMessage) Decode(data []byte) (err error) {
+	return m.decode(data, MessageCompressedPart, false)
+}
+
+func (m *CompressedPartMessage) decode(data []byte, mType uint8, large bool) (err error) {
+	size := offsetSize(large)
+	header, end, err := decodeMessageHeader(data, mType, 16+size+4)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	copy(m.Hash[:], data[pos:pos+16])
+	pos += 16
+	m.Start = readOffset(data[pos:], large)
+	pos += size
+	m.PackedSize = binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
+	m.Data = append([]byte(nil), data[pos:end]...)
+	m.Header = header
+	return
+}
+
+// Type is the message type.
+func (m CompressedPartMessage) Type() uint8 {
+	return MessageCompressedPart
+}
+
+func (m CompressedPartMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[compressed-part]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, start: %d, packed size: %d, block: %d", m.Hash, m.Start, m.PackedSize, len(m.Data))
+	return b.String()
+}
+
+// CompressedPartI64Message message is CompressedPartMessage with 64-bit offset, for files larger than 4GB.
+type CompressedPartI64Message struct {
+	CompressedPartMessage
+}
+
+// Encode encodes the message to binary data.
+func (m *CompressedPartI64Message) Encode() (data []byte, err error) {
+	return m.encode(MessageCompressedPartI64, true)
+}
+
+// Decode decodes the message from binary data.
+func (m *CompressedPartI64Message) Decode(data []byte) (err error) {
+	return m.decode(data, MessageCompressedPartI64, true)
+}
+
+// Type is the message type.
+func (m CompressedPartI64Message) Type() uint8 {
+	return MessageCompressedPartI64
+}
+
+func (m CompressedPartI64Message) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[compressed-part-i64]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, start: %d, packed size: %d, block: %d", m.Hash, m.Start, m.PackedSize, len(m.Data))
+	return b.String()
+}
+
+// RequestPartsI64Message message is RequestPartsMessage with 64-bit offsets, for files larger than 4GB.
+type RequestPartsI64Message struct {
+	RequestPartsMessage
+}
+
+// Encode encodes the message to binary data.
+func (m *RequestPartsI64Message) Encode() (data []byte, err error) {
+	return m.encode(emuleHeader(m.Header), MessageRequestPartsI64, true)
+}
+
+// Decode decodes the message from binary data.
+func (m *RequestPartsI64Message) Decode(data []byte) (err error) {
+	return m.decode(data, MessageRequestPartsI64, true)
+}
+
+// Type is the message type.
+func (m RequestPartsI64Message) Type() uint8 {
+	return MessageRequestPartsI64
+}
+
+func (m RequestPartsI64Message) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[request-parts-i64]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X", m.Hash)
+	for i, r := range m.Ranges {
+		fmt.Fprintf(&b, "\n%d - %d-%d", i, r.Start, r.End)
+	}
+	return b.String()
+}
+
+// SendingPartI64Message message is SendingPartMessage with 64-bit offsets, for files larger than 4GB.
+type SendingPartI64Message struct {
+	SendingPartMessage
+}
+
+// Encode encodes the message to binary data.
+func (m *SendingPartI64Message) Encode() (data []byte, err error) {
+	return m.encode(emuleHeader(m.Header), MessageSendingPartI64, true)
+}
+
+// Decode decodes the message from binary data.
+func (m *SendingPartI64Message) Decode(data []byte) (err error) {
+	return m.decode(data, MessageSendingPartI64, true)
+}
+
+// Type is the message type.
+func (m SendingPartI64Message) Type() uint8 {
+	return MessageSendingPartI64
+}
+
+func (m SendingPartI64Message) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[sending-part-i64]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, range: %d-%d", m.Hash, m.Start, m.End)
+	return b.String()
+}
+
+// QueueRankingMessage message is sent by the uploading eMule client when the downloading client is queued,
+// it replaces QueueRankMessage.
+type QueueRankingMessage struct {
+	message
+	Rank uint16
+}
+
+// Encode encodes the message to binary data.
+func (m *QueueRankingMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), MessageQueueRanking); err != nil {
+		return
+	}
+	binary.Write(buf, binary.LittleEndian, m.Rank)
+	buf.Write(make([]byte, 10)) // reserved
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *QueueRankingMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageQueueRanking, 2)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	m.Rank = binary.LittleEndian.Uint16(data[HeaderLength+1:])
+	return
+}
+
+// Type is the message type.
+func (m QueueRankingMessage) Type() uint8 {
+	return MessageQueueRanking
+}
+
+func (m QueueRankingMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[queue-ranking]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "rank: %d", m.Rank)
+	return b.String()
+}
+
+// RequestSourcesMessage message is sent to request the sources known by the remote client for a file (source exchange).
+type RequestSourcesMessage struct {
+	message
+	Hash [16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *RequestSourcesMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), MessageRequestSources); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *RequestSourcesMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageRequestSources, 16)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	copy(m.Hash[:], data[HeaderLength+1:])
+	return
+}
+
+// Type is the message type.
+func (m RequestSourcesMessage) Type() uint8 {
+	return MessageRequestSources
+}
+
+func (m RequestSourcesMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[request-sources]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X", m.Hash)
+	return b.String()
+}
+
+// RequestSources2Message message is RequestSourcesMessage with the source exchange version of the answer.
+type RequestSources2Message struct {
+	message
+	// The source exchange version, SourceExchangeVersion.
+	Version uint8
+	// Reserved options.
+	Options uint16
+	Hash    [16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *RequestSources2Message) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), MessageRequestSources2); err != nil {
+		return
+	}
+	buf.WriteByte(m.Version)
+	binary.Write(buf, binary.LittleEndian, m.Options)
+	buf.Write(m.Hash[:])
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *RequestSources2Message) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageRequestSources2, 1+2+16)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	m.Header = header
+	m.Version = data[pos]
+	m.Options = binary.LittleEndian.Uint16(data[pos+1 : pos+3])
+	copy(m.Hash[:], data[pos+3:pos+19])
+	return
+}
+
+// Type is the message type.
+func (m RequestSources2Message) Type() uint8 {
+	return MessageRequestSources2
+}
+
+func (m RequestSources2Message) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[request-sources2]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "version: %d, options: %#x, hash: %X", m.Version, m.Options, m.Hash)
+	return b.String()
+}
+
+// ExchangeSource is a source of a file sent by source exchange.
+type ExchangeSource struct {
+	// The client ID, since source exchange version 3 a high ID is sent as IP in host byte order.
+	ClientID   ClientID
+	Port       uint16
+	ServerIP   ClientID
+	ServerPort uint16
+	// The user hash, since source exchange version 2.
+	UserHash UID
+	// The Source* crypt options, since source exchange version 4.
+	CryptOptions uint8
+}
+
+// exchangeSourceSize returns the size of a source entry of the source exchange version.
+func exchangeSourceSize(version uint8) int {
+	switch {
+	case version >= 4:
+		return 29
+	case version >= 2:
+		return 28
+	}
+	return 12
+}
+
+func writeExchangeSources(buf *bytes.Buffer, version uint8, sources []ExchangeSource) error {
+	if len(sources) > math.MaxUint16 {
+		return ErrMessageTooLarge
+	}
+	binary.Write(buf, binary.LittleEndian, uint16(len(sources)))
+	for _, s := range sources {
+		binary.Write(buf, binary.LittleEndian, s.ClientID)
+		binary.Write(buf, binary.LittleEndian, s.Port)
+		binary.Write(buf, binary.LittleEndian, s.ServerIP)
+		binary.Write(buf, binary.LittleEndian, s.ServerPort)
+		if version >= 2 {
+			buf.Write(s.UserHash[:])
+		}
+		if version >= 4 {
+			buf.WriteByte(s.CryptOptions)
+		}
+	}
+	return nil
+}
+
+// readExchangeSources reads count source entries of the source exchange version from data.
+func readExchangeSources(data []byte, version uint8, count int) (sources []ExchangeSource, err error) {
+	size := exchangeSourceSize(version)
+	if len(data) < count*size {
+		err = ErrShortBuffer
+		return
+	}
+	for i := 0; i < count; i++ {
+		b := data[i*size : (i+1)*size]
+		s := ExchangeSource{
+			ClientID:   ClientID(binary.LittleEndian.Uint32(b[0:4])),
+			Port:       binary.LittleEndian.Uint16(b[4:6]),
+			ServerIP:   ClientID(binary.LittleEndian.Uint32(b[6:10])),
+			ServerPort: binary.LittleEndian.Uint16(b[10:12]),
+		}
+		if version >= 2 {
+			copy(s.UserHash[:], b[12:28])
+		}
+		if version >= 4 {
+			s.CryptOptions = b[28]
+		}
+		sources = append(sources, s)
+	}
+	return
+}
+
+// AnswerSourcesMessage message is sent as an answer to RequestSourcesMessage.
+type AnswerSourcesMessage struct {
+	message
+	Hash [16]byte
+	// The source exchange version of the sources, the version negotiated in the hello handshake.
+	// It is not sent, the decoder infers it from the size of the sources, 2 for version 2 and 3.
+	Version uint8
+	Sources []ExchangeSource
+}
+
+// Encode encodes the message to binary data.
+func (m *AnswerSourcesMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), MessageAnswerSources); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+	if err = writeExchangeSources(buf, m.Version, m.Sources); err != nil {
+		return
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *AnswerSourcesMessage) Decode(data []byte) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageAnswerSources, 16+2)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	copy(m.Hash[:], data[pos:pos+16])
+	pos += 16
+	count := int(binary.LittleEndian.Uint16(data[pos : pos+2]))
+	pos += 2
+
+	version := uint8(1)
+	if count > 0 {
+		switch (end - pos) / count {
+		case exchangeSourceSize(1):
+		case exchangeSourceSize(2):
+			version = 2
+		case exchangeSourceSize(4):
+			version = 4
+		default:
+			return decodeError(MessageAnswerSources, pos, fmt.Sprintf("source count %d", count), ErrShortBuffer)
+		}
+	}
+	if m.Sources, err = readExchangeSources(data[pos:end], version, count); err != nil {
+		return decodeError(MessageAnswerSources, pos, "sources", err)
+	}
+	m.Header = header
+	m.Version = version
+	return
+}
+
+// Type is the message type.
+func (m AnswerSourcesMessage) Type() uint8 {
+	return MessageAnswerSources
+}
+
+func (m AnswerSourcesMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[answer-sources]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, version: %d", m.Hash, m.Version)
+	for i, s := range m.Sources {
+		fmt.Fprintf(&b, "\nsource%d - %s:%d, server: %s:%d", i, s.ClientID, s.Port, s.ServerIP, s.ServerPort)
+	}
+	return b.String()
+}
+
+// AnswerSources2Message message is sent as an answer to RequestSources2Message, with the source exchange version.
+type AnswerSources2Message struct {
+	message
+	Version uint8
+	Hash    [16]byte
+	Sources []ExchangeSource
+}
+
+// Encode encodes the message to binary data.
+func (m *AnswerSources2Message) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), MessageAnswerSources2); err != nil {
+		return
+	}
+	buf.WriteByte(m.Version)
+	buf.Write(m.Hash[:])
+	if err = writeExchangeSources(buf, m.Version, m.Sources); err != nil {
+		return
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *AnswerSources2Message) Decode(data []byte) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageAnswerSources2, 1+16+2)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	version := data[pos]
+	pos++
+	copy(m.Hash[:], data[pos:pos+16])
+	pos += 16
+	count := int(binary.LittleEndian.Uint16(data[pos : pos+2]))
+	pos += 2
+	if m.Sources, err = readExchangeSources(data[pos:end], version, count); err != nil {
+		return decodeError(MessageAnswerSources2, pos, "sources", err)
+	}
+	m.Header = header
+	m.Version = version
+	return
+}
+
+// Type is the message type.
+func (m AnswerSources2Message) Type() uint8 {
+	return MessageAnswerSources2
+}
+
+func (m AnswerSources2Message) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[answer-sources2]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, version: %d", m.Hash, m.Version)
+	for i, s := range m.Sources {
+		fmt.Fprintf(&b, "\nsource%d - %s:%d, server: %s:%d", i, s.ClientID, s.Port, s.ServerIP, s.ServerPort)
+	}
+	return b.String()
+}
+
+// FileIdentifier identifies a file by its hash, and optionally its size and AICH root hash.
+type FileIdentifier struct {
+	Hash [16]byte
+	// The file size, it is not sent if 0.
+	Size uint64
+	// The AICH root hash, it is not sent if zero.
+	AICHHash [20]byte
+}
+
+// file identifier options.
+const (
+	fileIdentifierMD4  = 0x01
+	fileIdentifierSize = 0x02
+	fileIdentifierAICH = 0x04
+	// options which must be understood by the reader, none is defined.
+	fileIdentifierMandatory = 0x18
+)
+
+func (f *FileIdentifier) writeTo(buf *bytes.Buffer) {
+	options := uint8(fileIdentifierMD4)
+	if f.Size != 0 {
+		options |= fileIdentifierSize
+	}
+	if f.AICHHash != ([20]byte{}) {
+		options |= fileIdentifierAICH
+	}
+	buf.WriteByte(options)
+	buf.Write(f.Hash[:])
+	if f.Size != 0 {
+		binary.Write(buf, binary.LittleEndian, f.Size)
+	}
+	if f.AICHHash != ([20]byte{}) {
+		buf.Write(f.AICHHash[:])
+	}
+}
+
+// readFileIdentifier reads the file identifier from data, n is the number of bytes read.
+func readFileIdentifier(data []byte) (f FileIdentifier, n int, err error) {
+	if len(data) < 1 {
+		err = ErrShortBuffer
+		return
+	}
+	options := data[0]
+	if options&fileIdentifierMD4 == 0 || options&fileIdentifierMandatory != 0 {
+		err = ErrFileIdentifier
+		return
+	}
+	n = 1 + 16
+	if options&fileIdentifierSize != 0 {
+		n += 8
+	}
+	if options&fileIdentifierAICH != 0 {
+		n += 20
+	}
+	if len(data) < n {
+		err = ErrShortBuffer
+		return
+	}
+	pos := 1
+	copy(f.Hash[:], data[pos:pos+16])
+	pos += 16
+	if options&fileIdentifierSize != 0 {
+		f.Size = binary.LittleEndian.Uint64(data[pos : pos+8])
+		pos += 8
+	}
+	if options&fileIdentifierAICH != 0 {
+		copy(f.AICHHash[:], data[pos:pos+20])
+	}
+	return
+}
+
+// MultiPacketMessage message is sent by the downloading eMule client to send the file requests at once,
+// instead of FileRequestMessage, SetRequestedFileIDMessage, RequestSourcesMessage and AICHFileHashRequestMessage.
+// The remote client answers with MultiPacketAnswerMessage, or NoSuchFileMessage.
+type MultiPacketMessage struct {
+	message
+	// The message version: 1 sends the file hash (MessageMultiPacket), 2 the file hash and size (MessageMultiPacketExt)
+	// and 3 the file identifier (MessageMultiPacketExt2).
+	Version int
+	// The file, the size is sent since version 2 and the AICH hash in version 3.
+	File FileIdentifier
+	// The extended requests version of the remote client, which sets the data of the file name request.
+	ExtRequestsVersion uint8
+
+	// FileName requests the file name.
+	FileName bool
+	// The part status of the file on the requesting client, sent with the file name request since
+	// extended requests version 1.
+	Parts []bool
+	// The number of complete sources of the file known by the requesting client, since extended requests version 2.
+	CompleteSources uint16
+	// FileStatus requests the file status.
+	FileStatus bool
+	// Sources requests the sources known by the remote client, with source exchange version SourcesVersion
+	// if it is not 0.
+	Sources        bool
+	SourcesVersion uint8
+	SourcesOptions uint16
+	// AICHHash requests the AICH root hash of the file.
+	AICHHash bool
+}
+
+func (m *MultiPacketMessage) opcode() uint8 {
+	switch m.Version {
+	case 2:
+		return MessageMultiPacketExt
+	case 3:
+		return MessageMultiPacketExt2
+	}
+	return MessageMultiPacket
+}
+
+// Encode encodes the message to binary data.
+func (m *MultiPacketMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), m.opcode()); err != nil {
+		return
+	}
+	switch m.Version {
+	case 2:
+		buf.Write(m.File.Hash[:])
+		binary.Write(buf, binary.LittleEndian, m.File.Size)
+	case 3:
+		m.File.writeTo(buf)
+	default:
+		buf.Write(m.File.Hash[:])
+	}
+
+	if m.FileName {
+		buf.WriteByte(MessageFileRequest)
+		if m.ExtRequestsVersion > 0 {
+			if err = writePartStatus(buf, m.Parts); err != nil {
+				return
+			}
+		}
+		if m.ExtRequestsVersion > 1 {
+			binary.Write(buf, binary.LittleEndian, m.CompleteSources)
+		}
+	}
+	if m.FileStatus {
+		buf.WriteByte(MessageSetRequestedFileID)
+	}
+	if m.Sources && m.SourcesVersion > 0 {
+		buf.WriteByte(MessageRequestSources2)
+		buf.WriteByte(m.SourcesVersion)
+		binary.Write(buf, binary.LittleEndian, m.SourcesOptions)
+	} else if m.Sources {
+		buf.WriteByte(MessageRequestSources)
+	}
+	if m.AICHHash {
+		buf.WriteByte(MessageAICHFileHashRequest)
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *MultiPacketMessage) Decode(data []byte) (err error) {
+	header, end, err := decodeMessageHeader(data, m.opcode(), 16)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	file := FileIdentifier{}
+	switch m.Version {
+	case 2:
+		if end < pos+16+8 {
+			return decodeError(m.opcode(), pos, "file size", ErrShortBuffer)
+		}
+		copy(file.Hash[:], data[pos:pos+16])
+		file.Size = binary.LittleEndian.Uint64(data[pos+16 : pos+24])
+		pos += 24
+	case 3:
+		var n int
+		if file, n, err = readFileIdentifier(data[pos:end]); err != nil {
+			return decodeError(m.opcode(), pos, "file identifier", err)
+		}
+		pos += n
+	default:
+		copy(file.Hash[:], data[pos:pos+16])
+		pos += 16
+	}
+
+	r := MultiPacketMessage{
+		Version:            m.Version,
+		File:               file,
+		ExtRequestsVersion: m.ExtRequestsVersion,
+	}
+	for pos < end {
+		opcode := data[pos]
+		pos++
+		switch opcode {
+		case MessageFileRequest:
+			r.FileName = true
+			if r.ExtRequestsVersion > 0 {
+				var n int
+				if r.Parts, n, err = readPartStatus(data[pos:end]); err != nil {
+					return decodeError(m.opcode(), pos, "part status", err)
+				}
+				pos += n
+			}
+			if r.ExtRequestsVersion > 1 {
+				if end < pos+2 {
+					return decodeError(m.opcode(), pos, "complete sources", ErrShortBuffer)
+				}
+				r.CompleteSources = binary.LittleEndian.Uint16(data[pos : pos+2])
+				pos += 2
+			}
+		case MessageSetRequestedFileID:
+			r.FileStatus = true
+		case MessageRequestSources:
+			r.Sources = true
+		case MessageRequestSources2:
+			if end < pos+3 {
+				return decodeError(m.opcode(), pos, "source exchange version", ErrShortBuffer)
+			}
+			r.Sources = true
+			r.SourcesVersion = data[pos]
+			r.SourcesOptions = binary.LittleEndian.Uint16(data[pos+1 : pos+3])
+			pos += 3
+		case MessageAICHFileHashRequest:
+			r.AICHHash = true
+		default:
+			return decodeError(m.opcode(), pos-1, fmt.Sprintf("opcode %#x", opcode), ErrMultiPacketOpcode)
+		}
+	}
+	r.Header = header
+	*m = r
+	return
+}
+
+// Type is the message type.
+func (m MultiPacketMessage) Type() uint8 {
+	return m.opcode()
+}
+
+func (m MultiPacketMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[multipacket]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "version: %d, hash: %X, size: %d\n", m.Version, m.File.Hash, m.File.Size)
+	fmt.Fprintf(&b, "file name: %v, file status: %v, sources: %v, aich hash: %v",
+		m.FileName, m.FileStatus, m.Sources, m.AICHHash)
+	return b.String()
+}
+
+// MultiPacketAnswerMessage message is sent as an answer to MultiPacketMessage.
+type MultiPacketAnswerMessage struct {
+	message
+	// Ext2 is true for the answer to a version 3 multipacket (MessageMultiPacketAnswerExt2), which sends the file identifier.
+	Ext2 bool
+	// The file, only the hash is sent if Ext2 is false.
+	File FileIdentifier
+	// The file name, it is not sent if empty.
+	Name string
+	// FileStatus is true if the part status Parts is sent, as FileStatusMessage.
+	FileStatus bool
+	Parts      []bool
+	// The AICH root hash of the file, it is not sent if zero.
+	AICHHash [20]byte
+}
+
+func (m *MultiPacketAnswerMessage) opcode() uint8 {
+	if m.Ext2 {
+		return MessageMultiPacketAnswerExt2
+	}
+	return MessageMultiPacketAnswer
+}
+
+// Encode encodes the message to binary data.
+func (m *MultiPacketAnswerMessage) Encode() (data []byte, err error) {
+	if len(m.Name) > math.MaxUint16 {
+		err = ErrMessageTooLarge
+		return
+	}
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), m.opcode()); err != nil {
+		return
+	}
+	if m.Ext2 {
+		m.File.writeTo(buf)
+	} else {
+		buf.Write(m.File.Hash[:])
+	}
+
+	if m.Name != "" {
+		buf.WriteByte(MessageFileRequestAnswer)
+		binary.Write(buf, binary.LittleEndian, uint16(len(m.Name)))
+		buf.WriteString(m.Name)
+	}
+	if m.FileStatus {
+		buf.WriteByte(MessageFileStatus)
+		if err = writePartStatus(buf, m.Parts); err != nil {
+			return
+		}
+	}
+	if m.AICHHash != ([20]byte{}) {
+		buf.WriteByte(MessageAICHFileHashAnswer)
+		buf.Write(m.AICHHash[:])
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *MultiPacketAnswerMessage) Decode(data []byte) (err error) {
+	header, end, err := decodeMessageHeader(data, m.opcode(), 16)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	r := MultiPacketAnswerMessage{Ext2: m.Ext2}
+	if m.Ext2 {
+		var n int
+		if r.File, n, err = readFileIdentifier(data[pos:end]); err != nil {
+			return decodeError(m.opcode(), pos, "file identifier", err)
+		}
+		pos += n
+	} else {
+		copy(r.File.Hash[:], data[pos:pos+16])
+		pos += 16
+	}
+
+	for pos < end {
+		opcode := data[pos]
+		pos++
+		switch opcode {
+		case MessageFileRequestAnswer:
+			if end < pos+2 {
+				return decodeError(m.opcode(), pos, "file name size", ErrShortBuffer)
+			}
+			n := int(binary.LittleEndian.Uint16(data[pos : pos+2]))
+			pos += 2
+			if end < pos+n {
+				return decodeError(m.opcode(), pos, "file name", ErrShortBuffer)
+			}
+			r.Name = string(data[pos : pos+n])
+			pos += n
+		case MessageFileStatus:
+			var n int
+			if r.Parts, n, err = readPartStatus(data[pos:end]); err != nil {
+				return decodeError(m.opcode(), pos, "part status", err)
+			}
+			r.FileStatus = true
+			pos += n
+		case MessageAICHFileHashAnswer:
+			if end < pos+20 {
+				return decodeError(m.opcode(), pos, "AICH hash", ErrShortBuffer)
+			}
+			copy(r.AICHHash[:], data[pos:pos+20])
+			pos += 20
+		default:
+			return decodeError(m.opcode(), pos-1, fmt.Sprintf("opcode %#x", opcode), ErrMultiPacketOpcode)
+		}
+	}
+	r.Header = header
+	*m = r
+	return
+}
+
+// Type is the message type.
+func (m MultiPacketAnswerMessage) Type() uint8 {
+	return m.opcode()
+}
+
+func (m MultiPacketAnswerMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[multipacket-answer]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, name: %s, file status: %v, aich hash: %X", m.File.Hash, m.Name, m.FileStatus, m.AICHHash)
+	return b.String()
+}
+
+// PublicIPRequestMessage message is sent to ask the remote client for the public IP address of the sender.
+type PublicIPRequestMessage struct {
+	message
+}
+
+// Encode encodes the message to binary data.
+func (m *PublicIPRequestMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), MessagePublicIPRequest); err != nil {
+		return
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *PublicIPRequestMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessagePublicIPRequest, 0)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	return
+}
+
+// Type is the message type.
+func (m PublicIPRequestMessage) Type() uint8 {
+	return MessagePublicIPRequest
+}
+
+func (m PublicIPRequestMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[public-ip-request]\n")
+	b.WriteString(m.Header.String())
+	return b.String()
+}
+
+// PublicIPAnswerMessage message is sent as an answer to PublicIPRequestMessage.
+type PublicIPAnswerMessage struct {
+	message
+	IP net.IP
+}
+
+// Encode encodes the message to binary data.
+func (m *PublicIPAnswerMessage) Encode() (data []byte, err error) {
+	ip := m.IP.To4()
+	if ip == nil {
+		ip = net.IPv4zero.To4()
+	}
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), MessagePublicIPAnswer); err != nil {
+		return
+	}
+	buf.Write(ip)
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *PublicIPAnswerMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessagePublicIPAnswer, 4)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	m.Header = header
+	m.IP = net.IPv4(data[pos], data[pos+1], data[pos+2], data[pos+3])
+	return
+}
+
+// Type is the message type.
+func (m PublicIPAnswerMessage) Type() uint8 {
+	return MessagePublicIPAnswer
+}
+
+func (m PublicIPAnswerMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[public-ip-answer]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "ip: %s", m.IP)
+	return b.String()
+}
+
+// AICHRequestMessage message is sent to request the AICH recovery data of a corrupted part.
+type AICHRequestMessage struct {
+	message
+	Hash [16]byte
+	Part uint16
+	// The AICH root hash of the file.
+	MasterHash [20]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *AICHRequestMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), MessageAICHRequest); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+	binary.Write(buf, binary.LittleEndian, m.Part)
+	buf.Write(m.MasterHash[:])
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *AICHRequestMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageAICHRequest, 16+2+20)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	m.Header = header
+	copy(m.Hash[:], data[pos:pos+16])
+	pos += 16
+	m.Part = binary.LittleEndian.Uint16(data[pos : pos+2])
+	pos += 2
+	copy(m.MasterHash[:], data[pos:pos+20])
+	return
+}
+
+// Type is the message type.
+func (m AICHRequestMessage) Type() uint8 {
+	return MessageAICHRequest
+}
+
+func (m AICHRequestMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[aich-request]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, part: %d, master hash: %X", m.Hash, m.Part, m.MasterHash)
+	return b.String()
+}
+
+// AICHAnswerMessage message is sent as an answer to AICHRequestMessage with the recovery data of the part.
+// If the remote client can't provide the recovery data, only the file hash is sent.
+type AICHAnswerMessage struct {
+	message
+	Hash [16]byte
+	// Failed is true if only the file hash is sent.
+	Failed     bool
+	Part       uint16
+	MasterHash [20]byte
+	// The AICH recovery hash set, it is not parsed.
+	Data []byte
+}
+
+// Encode encodes the message to binary data.
+func (m *AICHAnswerMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), MessageAICHAnswer); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+	if !m.Failed {
+		binary.Write(buf, binary.LittleEndian, m.Part)
+		buf.Write(m.MasterHash[:])
+		buf.Write(m.Data)
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *AICHAnswerMessage) Decode(data []byte) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageAICHAnswer, 16)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	r := AICHAnswerMessage{}
+	copy(r.Hash[:], data[pos:pos+16])
+	pos += 16
+	if pos == end {
+		r.Failed = true
+	} else {
+		if end < pos+2+20 {
+			return decodeError(MessageAICHAnswer, pos, "AICH data", ErrShortBuffer)
+		}
+		r.Part = binary.LittleEndian.Uint16(data[pos : pos+2])
+		pos += 2
+		copy(r.MasterHash[:], data[pos:pos+20])
+		pos += 20
+		r.Data = append([]byte(nil), data[pos:end]...)
+	}
+	r.Header = header
+	*m = r
+	return
+}
+
+// Type is the message type.
+func (m AICHAnswerMessage) Type() uint8 {
+	return MessageAICHAnswer
+}
+
+func (m AICHAnswerMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[aich-answer]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	if m.Failed {
+		fmt.Fprintf(&b, "hash: %X, failed", m.Hash)
+		return b.String()
+	}
+	fmt.Fprintf(&b, "hash: %X, part: %d, master hash: %X, data: %d", m.Hash, m.Part, m.MasterHash, len(m.Data))
+	return b.String()
+}
+
+// AICHFileHashRequestMessage message is sent to request the AICH root hash of a file.
+type AICHFileHashRequestMessage struct {
+	message
+	Hash [16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *AICHFileHashRequestMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), MessageAICHFileHashRequest); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *AICHFileHashRequestMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageAICHFileHashRequest, 16)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	copy(m.Hash[:], data[HeaderLength+1:])
+	return
+}
+
+// Type is the message type.
+func (m AICHFileHashRequestMessage) Type() uint8 {
+	return MessageAICHFileHashRequest
+}
+
+func (m AICHFileHashRequestMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[aich-file-hash-request]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X", m.Hash)
+	return b.String()
+}
+
+// AICHFileHashAnswerMessage message is sent as an answer to AICHFileHashRequestMessage.
+type AICHFileHashAnswerMessage struct {
+	message
+	Hash     [16]byte
+	AICHHash [20]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *AICHFileHashAnswerMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, emuleHeader(m.Header), MessageAICHFileHashAnswer); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+	buf.Write(m.AICHHash[:])
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *AICHFileHashAnswerMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageAICHFileHashAnswer, 16+20)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	m.Header = header
+	copy(m.Hash[:], data[pos:pos+16])
+	copy(m.AICHHash[:], data[pos+16:pos+36])
+	return
+}
+
+// Type is the message type.
+func (m AICHFileHashAnswerMessage) Type() uint8 {
+	return MessageAICHFileHashAnswer
+}
+
+func (m AICHFileHashAnswerMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[aich-file-hash-answer]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, aich hash: %X", m.Hash, m.AICHHash)
+	return b.String()
+}
@@ -0,0 +1,139 @@
+package ed2k
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestCCEMuleMessage(t *testing.T) {
+	hash := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	aich := bytes.Repeat([]byte{0xAA}, 20)
+	with := func(b ...[]byte) []byte {
+		return append(append([]byte{}, hash...), bytes.Join(b, nil)...)
+	}
+	source := []byte{1, 0, 0, 0, 0x36, 0x12, 192, 168, 1, 1, 0x37, 0x12}
+
+	testCases := [][]byte{
+		tcpMessage(ProtoEMule, MessageEMuleInfo, 0x30, EMuleProtocolVersion, 1, 0, 0, 0, TagUint8|0x80, 0x20, 1),
+		tcpMessage(ProtoEMule, MessageEMuleInfoAnswer, 0x30, EMuleProtocolVersion, 0, 0, 0, 0),
+		tcpMessage(ProtoEMule, MessageCompressedPart, with([]byte{0, 0x28, 0, 0, 3, 0, 0, 0, 'a', 'b'})...),
+		tcpMessage(ProtoEMule, MessageCompressedPartI64, with([]byte{0, 0x28, 0, 0, 1, 0, 0, 0, 3, 0, 0, 0, 'a'})...),
+		tcpMessage(ProtoEMule, MessageQueueRanking, 10, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0),
+		tcpMessage(ProtoEMule, MessageRequestSources, hash...),
+		tcpMessage(ProtoEMule, MessageAnswerSources, with([]byte{1, 0}, source)...),
+		tcpMessage(ProtoEMule, MessageAnswerSources, with([]byte{2, 0}, source, hash, source, hash)...),
+		tcpMessage(ProtoEMule, MessageAnswerSources, with([]byte{1, 0}, source, hash, []byte{SourceCryptSupport})...),
+		tcpMessage(ProtoEMule, MessageRequestSources2, append([]byte{SourceExchangeVersion, 0, 0}, hash...)...),
+		tcpMessage(ProtoEMule, MessageAnswerSources2, append([]byte{SourceExchangeVersion}, with([]byte{1, 0}, source, hash, []byte{0})...)...),
+		tcpMessage(ProtoEMule, MessageAnswerSources2, append([]byte{1}, with([]byte{1, 0}, source)...)...),
+		tcpMessage(ProtoEMule, MessageMultiPacket, with(
+			[]byte{MessageFileRequest, 10, 0, 0x81, 0x02, 5, 0},
+			[]byte{MessageSetRequestedFileID, MessageRequestSources, MessageAICHFileHashRequest})...),
+		tcpMessage(ProtoEMule, MessageMultiPacketExt, with(
+			[]byte{0, 0, 0, 0, 1, 0, 0, 0},
+			[]byte{MessageFileRequest, 0, 0, 0, 0, MessageSetRequestedFileID})...),
+		tcpMessage(ProtoEMule, MessageMultiPacketExt2, bytes.Join([][]byte{
+			{0x07}, hash, {0, 0, 0, 0, 1, 0, 0, 0}, aich,
+			{MessageRequestSources2, SourceExchangeVersion, 0, 0}}, nil)...),
+		tcpMessage(ProtoEMule, MessageMultiPacketAnswer, with(
+			[]byte{MessageFileRequestAnswer, 3, 0, 'a', 'b', 'c'},
+			[]byte{MessageFileStatus, 0, 0},
+			[]byte{MessageAICHFileHashAnswer}, aich)...),
+		tcpMessage(ProtoEMule, MessageMultiPacketAnswerExt2, bytes.Join([][]byte{
+			{0x03}, hash, {0, 0, 0, 0, 1, 0, 0, 0},
+			{MessageFileStatus, 10, 0, 0x81, 0x02}}, nil)...),
+		tcpMessage(ProtoEMule, MessageRequestPartsI64, with(bytes.Repeat([]byte{0, 0, 0, 0, 1, 0, 0, 0}, 6))...),
+		tcpMessage(ProtoEMule, MessageSendingPartI64, with(
+			[]byte{0, 0, 0, 0, 1, 0, 0, 0}, []byte{3, 0, 0, 0, 1, 0, 0, 0}, []byte{'a', 'b', 'c'})...),
+		tcpMessage(ProtoEMule, MessagePublicIPRequest),
+		tcpMessage(ProtoEMule, MessagePublicIPAnswer, 192, 168, 1, 1),
+		tcpMessage(ProtoEMule, MessageAICHRequest, with([]byte{2, 0}, aich)...),
+		tcpMessage(ProtoEMule, MessageAICHAnswer, with([]byte{2, 0}, aich, []byte{1, 2, 3})...),
+		tcpMessage(ProtoEMule, MessageAICHAnswer, hash...),
+		tcpMessage(ProtoEMule, MessageAICHFileHashRequest, hash...),
+		tcpMessage(ProtoEMule, MessageAICHFileHashAnswer, with(aich)...),
+	}
+
+	for i, tc := range testCases {
+		m, err := ReadMessage(bytes.NewReader(tc), CCTCPMessage)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if m.Type() != tc[5] {
+			t.Errorf("%d: type %#x, want %#x", i, m.Type(), tc[5])
+		}
+		if _, ok := m.(*RawMessage); ok {
+			t.Errorf("%d: message %#x not registered", i, tc[5])
+		}
+		b, err := m.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(b, tc) {
+			t.Errorf("%d: %s\n%# x\n%# x", i, m, b, tc)
+		}
+	}
+}
+
+func TestCCEMuleMessageDecode(t *testing.T) {
+	// eDonkey and eMule opcodes don't collide.
+	m, err := ReadMessage(bytes.NewReader(tcpMessage(ProtoEMule, MessageEMuleInfo, 0x30, 1, 0, 0, 0, 0)), CCTCPMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := m.(*EMuleInfoMessage); !ok {
+		t.Errorf("got %T, want EMuleInfoMessage", m)
+	}
+
+	// encoded with the eMule protocol by default.
+	b, err := (&PublicIPAnswerMessage{IP: net.IPv4(1, 2, 3, 4)}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b[0] != ProtoEMule {
+		t.Errorf("protocol %#x, want %#x", b[0], ProtoEMule)
+	}
+
+	m, err = ReadMessage(bytes.NewReader(tcpMessage(ProtoEMule, MessageMultiPacket,
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16,
+		MessageFileRequest, 10, 0, 0x81, 0x02, 5, 0, MessageRequestSources)), CCTCPMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	multi := m.(*MultiPacketMessage)
+	if !multi.FileName || len(multi.Parts) != 10 || !multi.Parts[9] || multi.CompleteSources != 5 ||
+		multi.FileStatus || !multi.Sources {
+		t.Errorf("unexpected message %v", multi)
+	}
+
+	m, err = ReadMessage(bytes.NewReader(tcpMessage(ProtoEMule, MessageAnswerSources2,
+		SourceExchangeVersion, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 1, 0,
+		1, 2, 3, 4, 0x36, 0x12, 0, 0, 0, 0, 0, 0,
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, SourceCryptRequest)), CCTCPMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer := m.(*AnswerSources2Message)
+	if len(answer.Sources) != 1 || answer.Sources[0].ClientID != 0x04030201 || answer.Sources[0].Port != 4662 ||
+		answer.Sources[0].UserHash[15] != 16 || answer.Sources[0].CryptOptions != SourceCryptRequest {
+		t.Errorf("unexpected message %v", answer)
+	}
+
+	invalid := [][]byte{
+		tcpMessage(ProtoEMule, MessageEMuleInfo, 0x30, 1, 1, 0, 0, 0),
+		tcpMessage(ProtoEMule, MessageAnswerSources, append(make([]byte, 16), 2, 0, 1, 2, 3)...),
+		tcpMessage(ProtoEMule, MessageAnswerSources2, append(make([]byte, 17), 1, 0, 1, 2, 3)...),
+		tcpMessage(ProtoEMule, MessageMultiPacket, append(make([]byte, 16), 0xEE)...),
+		tcpMessage(ProtoEMule, MessageMultiPacket, append(make([]byte, 16), MessageFileRequest, 0xFF, 0xFF)...),
+		tcpMessage(ProtoEMule, MessageMultiPacketExt2, append([]byte{0x02}, make([]byte, 24)...)...),
+		tcpMessage(ProtoEMule, MessageMultiPacketAnswer, append(make([]byte, 16), MessageFileRequestAnswer, 9, 0, 'a')...),
+		tcpMessage(ProtoEMule, MessageAICHAnswer, append(make([]byte, 16), 1)...),
+		tcpMessage(ProtoEMule, MessageSendingPartI64, make([]byte, 16+8+8)[:20]...),
+	}
+	for i, tc := range invalid {
+		if m, err := ReadMessage(bytes.NewReader(tc), CCTCPMessage); err == nil {
+			t.Errorf("%d: invalid message decoded: %v", i, m)
+		}
+	}
+}
@@ -3,18 +3,24 @@ package ed2k
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"io"
+	"math"
 	"net"
 )
 
+// errors
+var (
+	ErrUserHashSize = errors.New("invalid user hash size")
+)
+
 // HelloMessage message is the first message in the handshake between two e-mule clients.
 type HelloMessage struct {
 	message
 	UID      UID
 	ClientID ClientID
 	Port     uint16
-	Tags     []Tag
+	Tags     Tags
 	// The address of the server to which the client is connected.
 	Server *net.TCPAddr
 }
@@ -60,23 +66,22 @@ func (m *HelloMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *HelloMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *HelloMessage) decode(data []byte, d *TagDecoder) (err error) {
+	// the server address is the last 6 bytes of the message.
+	header, end, err := decodeMessageHeader(data, MessageHello, 1+16+4+2+4+6)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+1+1+16+4+2+4 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageHello {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 	size := int(data[pos])
 	pos++
+	if size != len(m.UID) {
+		return decodeError(MessageHello, pos-1, fmt.Sprintf("user hash size %d", size), ErrUserHashSize)
+	}
 	copy(m.UID[:], data[pos:pos+size])
 
 	pos += size
@@ -89,18 +94,11 @@ func (m *HelloMessage) Decode(data []byte) (err error) {
 	tagCount := binary.LittleEndian.Uint32(data[pos : pos+4])
 
 	pos += 4
-	r := bytes.NewReader(data[pos:])
-	for i := 0; i < int(tagCount); i++ {
-		tag, err := ReadTag(r)
-		if err != nil {
-			return err
-		}
-		m.Tags = append(m.Tags, tag)
-	}
-	b := make([]byte, 6)
-	if _, err = io.ReadFull(r, b); err != nil {
+	if m.Tags, _, err = readTags(d, data, pos, end-6, tagCount, MessageHello); err != nil {
 		return
 	}
+	b := make([]byte, 6)
+	copy(b, data[end-6:end])
 	m.Server = &net.TCPAddr{
 		IP:   net.IP(b[:4]),
 		Port: int(binary.LittleEndian.Uint16(b[4:6])),
@@ -133,7 +131,7 @@ type HelloAnswerMessage struct {
 	UID      UID
 	ClientID ClientID
 	Port     uint16
-	Tags     []Tag
+	Tags     Tags
 	// The address of the server to which the client is connected.
 	Server *net.TCPAddr
 }
@@ -147,7 +145,7 @@ func (m *HelloAnswerMessage) Encode() (data []byte, err error) {
 	if _, err = m.Header.WriteTo(buf); err != nil {
 		return
 	}
-	buf.WriteByte(MessageHello)
+	buf.WriteByte(MessageHelloAnswer)
 	buf.Write(m.UID.Bytes())
 	binary.Write(buf, binary.LittleEndian, m.ClientID)
 	binary.Write(buf, binary.LittleEndian, m.Port)
@@ -178,21 +176,17 @@ func (m *HelloAnswerMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *HelloAnswerMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *HelloAnswerMessage) decode(data []byte, d *TagDecoder) (err error) {
+	// the server address is the last 6 bytes of the message.
+	header, end, err := decodeMessageHeader(data, MessageHelloAnswer, 16+4+2+4+6)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+1+16+4+2+4 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageHelloAnswer {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 	copy(m.UID[:], data[pos:pos+16])
 
 	pos += 16
@@ -205,18 +199,11 @@ func (m *HelloAnswerMessage) Decode(data []byte) (err error) {
 	tagCount := binary.LittleEndian.Uint32(data[pos : pos+4])
 
 	pos += 4
-	r := bytes.NewReader(data[pos:])
-	for i := 0; i < int(tagCount); i++ {
-		tag, err := ReadTag(r)
-		if err != nil {
-			return err
-		}
-		m.Tags = append(m.Tags, tag)
-	}
-	b := make([]byte, 6)
-	if _, err = io.ReadFull(r, b); err != nil {
+	if m.Tags, _, err = readTags(d, data, pos, end-6, tagCount, MessageHelloAnswer); err != nil {
 		return
 	}
+	b := make([]byte, 6)
+	copy(b, data[end-6:end])
 	m.Server = &net.TCPAddr{
 		IP:   net.IP(b[:4]),
 		Port: int(binary.LittleEndian.Uint16(b[4:6])),
@@ -242,3 +229,857 @@ func (m HelloAnswerMessage) String() string {
 	b.WriteString("server: " + m.Server.String())
 	return b.String()
 }
+
+// FileRequestMessage message is sent by a downloading client to request the name of a file,
+// the first step of the file request, the remote client answers with FileRequestAnswerMessage or NoSuchFileMessage.
+type FileRequestMessage struct {
+	message
+	Hash [16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *FileRequestMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageFileRequest); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+// The extended request data sent by eMule clients after the hash is ignored.
+func (m *FileRequestMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageFileRequest, 16)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	copy(m.Hash[:], data[HeaderLength+1:])
+	return
+}
+
+// Type is the message type.
+func (m FileRequestMessage) Type() uint8 {
+	return MessageFileRequest
+}
+
+func (m FileRequestMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[file-request]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X", m.Hash)
+	return b.String()
+}
+
+// FileRequestAnswerMessage message is sent as an answer to FileRequestMessage with the name of the file.
+type FileRequestAnswerMessage struct {
+	message
+	Hash [16]byte
+	Name string
+}
+
+// Encode encodes the message to binary data.
+func (m *FileRequestAnswerMessage) Encode() (data []byte, err error) {
+	if len(m.Name) > math.MaxUint16 {
+		err = ErrMessageTooLarge
+		return
+	}
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageFileRequestAnswer); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+	binary.Write(buf, binary.LittleEndian, uint16(len(m.Name)))
+	buf.WriteString(m.Name)
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *FileRequestAnswerMessage) Decode(data []byte) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageFileRequestAnswer, 16+2)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	copy(m.Hash[:], data[pos:pos+16])
+	pos += 16
+	n := int(binary.LittleEndian.Uint16(data[pos : pos+2]))
+	pos += 2
+	if end < pos+n {
+		return decodeError(MessageFileRequestAnswer, pos, "file name", ErrShortBuffer)
+	}
+	m.Header = header
+	m.Name = string(data[pos : pos+n])
+	return
+}
+
+// Type is the message type.
+func (m FileRequestAnswerMessage) Type() uint8 {
+	return MessageFileRequestAnswer
+}
+
+func (m FileRequestAnswerMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[file-request-answer]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, name: %s", m.Hash, m.Name)
+	return b.String()
+}
+
+// SetRequestedFileIDMessage message is sent by a downloading client to select the file it wants to download,
+// the remote client answers with FileStatusMessage or NoSuchFileMessage.
+type SetRequestedFileIDMessage struct {
+	message
+	Hash [16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *SetRequestedFileIDMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageSetRequestedFileID); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *SetRequestedFileIDMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageSetRequestedFileID, 16)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	copy(m.Hash[:], data[HeaderLength+1:])
+	return
+}
+
+// Type is the message type.
+func (m SetRequestedFileIDMessage) Type() uint8 {
+	return MessageSetRequestedFileID
+}
+
+func (m SetRequestedFileIDMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[set-requested-file-id]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X", m.Hash)
+	return b.String()
+}
+
+// FileStatusMessage message is sent as an answer to SetRequestedFileIDMessage with the parts available for download.
+type FileStatusMessage struct {
+	message
+	Hash [16]byte
+	// The availability of each part (FileChunkSize) of the file.
+	// It is empty if the file is complete, a complete file is sent with a part count of 0.
+	Parts []bool
+}
+
+// Complete reports whether the remote client has all the parts of the file.
+func (m *FileStatusMessage) Complete() bool {
+	for _, ok := range m.Parts {
+		if !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Encode encodes the message to binary data.
+func (m *FileStatusMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageFileStatus); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+	if err = writePartStatus(buf, m.Parts); err != nil {
+		return
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *FileStatusMessage) Decode(data []byte) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageFileStatus, 16+2)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	copy(m.Hash[:], data[pos:pos+16])
+	pos += 16
+	parts, _, err := readPartStatus(data[pos:end])
+	if err != nil {
+		return decodeError(MessageFileStatus, pos, "part status", err)
+	}
+	m.Header = header
+	m.Parts = parts
+	return
+}
+
+// Type is the message type.
+func (m FileStatusMessage) Type() uint8 {
+	return MessageFileStatus
+}
+
+func (m FileStatusMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[file-status]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, parts: ", m.Hash)
+	if len(m.Parts) == 0 {
+		b.WriteString("complete")
+	}
+	for _, ok := range m.Parts {
+		if ok {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+// writePartStatus writes the part count and the parts bitmap to buf,
+// the first part is the lowest bit of the first byte.
+func writePartStatus(buf *bytes.Buffer, parts []bool) error {
+	if len(parts) > math.MaxUint16 {
+		return ErrMessageTooLarge
+	}
+	binary.Write(buf, binary.LittleEndian, uint16(len(parts)))
+	bitmap := make([]byte, (len(parts)+7)/8)
+	for i, ok := range parts {
+		if ok {
+			bitmap[i/8] |= 1 << uint(i%8)
+		}
+	}
+	_, err := buf.Write(bitmap)
+	return err
+}
+
+// readPartStatus reads the part status written by writePartStatus from data, n is the number of bytes read.
+func readPartStatus(data []byte) (parts []bool, n int, err error) {
+	if len(data) < 2 {
+		err = ErrShortBuffer
+		return
+	}
+	count := int(binary.LittleEndian.Uint16(data))
+	n = 2 + (count+7)/8
+	if len(data) < n {
+		err = ErrShortBuffer
+		return
+	}
+	for i := 0; i < count; i++ {
+		parts = append(parts, data[2+i/8]&(1<<uint(i%8)) != 0)
+	}
+	return
+}
+
+// NoSuchFileMessage message is sent as an answer to a file request when the requested file is not shared.
+type NoSuchFileMessage struct {
+	message
+	Hash [16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *NoSuchFileMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageNoSuchFile); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *NoSuchFileMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageNoSuchFile, 16)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	copy(m.Hash[:], data[HeaderLength+1:])
+	return
+}
+
+// Type is the message type.
+func (m NoSuchFileMessage) Type() uint8 {
+	return MessageNoSuchFile
+}
+
+func (m NoSuchFileMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[no-such-file]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X", m.Hash)
+	return b.String()
+}
+
+// HashSetRequestMessage message is sent by a downloading client to request the part hashes of a file.
+type HashSetRequestMessage struct {
+	message
+	Hash [16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *HashSetRequestMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageHashSetRequest); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *HashSetRequestMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageHashSetRequest, 16)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	copy(m.Hash[:], data[HeaderLength+1:])
+	return
+}
+
+// Type is the message type.
+func (m HashSetRequestMessage) Type() uint8 {
+	return MessageHashSetRequest
+}
+
+func (m HashSetRequestMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[hashset-request]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X", m.Hash)
+	return b.String()
+}
+
+// HashSetAnswerMessage message is sent as an answer to HashSetRequestMessage with the part hashes of the file.
+type HashSetAnswerMessage struct {
+	message
+	Hash       [16]byte
+	PartHashes [][16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *HashSetAnswerMessage) Encode() (data []byte, err error) {
+	if len(m.PartHashes) > math.MaxUint16 {
+		err = ErrMessageTooLarge
+		return
+	}
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageHashSetAnswer); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+	binary.Write(buf, binary.LittleEndian, uint16(len(m.PartHashes)))
+	for _, hash := range m.PartHashes {
+		buf.Write(hash[:])
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *HashSetAnswerMessage) Decode(data []byte) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageHashSetAnswer, 16+2)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	copy(m.Hash[:], data[pos:pos+16])
+	pos += 16
+	count := int(binary.LittleEndian.Uint16(data[pos : pos+2]))
+	pos += 2
+	if end < pos+count*16 {
+		return decodeError(MessageHashSetAnswer, pos, "part hash count", ErrShortBuffer)
+	}
+	m.Header = header
+	m.PartHashes = make([][16]byte, count)
+	for i := range m.PartHashes {
+		copy(m.PartHashes[i][:], data[pos:pos+16])
+		pos += 16
+	}
+	return
+}
+
+// Type is the message type.
+func (m HashSetAnswerMessage) Type() uint8 {
+	return MessageHashSetAnswer
+}
+
+func (m HashSetAnswerMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[hashset-answer]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X", m.Hash)
+	for i, hash := range m.PartHashes {
+		fmt.Fprintf(&b, "\n%d - %X", i, hash)
+	}
+	return b.String()
+}
+
+// StartUploadRequestMessage message is sent by a downloading client to ask for an upload slot,
+// the remote client answers with AcceptUploadMessage or QueueRankMessage.
+type StartUploadRequestMessage struct {
+	message
+	// The hash of the requested file, it is not sent by old eDonkey clients.
+	Hash [16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *StartUploadRequestMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageStartUploadRequest); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *StartUploadRequestMessage) Decode(data []byte) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageStartUploadRequest, 0)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	m.Header = header
+	m.Hash = [16]byte{}
+	if end >= pos+16 {
+		copy(m.Hash[:], data[pos:pos+16])
+	}
+	return
+}
+
+// Type is the message type.
+func (m StartUploadRequestMessage) Type() uint8 {
+	return MessageStartUploadRequest
+}
+
+func (m StartUploadRequestMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[start-upload-request]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X", m.Hash)
+	return b.String()
+}
+
+// AcceptUploadMessage message is sent when the uploading client grants an upload slot,
+// the downloading client then sends RequestPartsMessage.
+type AcceptUploadMessage struct {
+	message
+}
+
+// Encode encodes the message to binary data.
+func (m *AcceptUploadMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageAcceptUpload); err != nil {
+		return
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *AcceptUploadMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageAcceptUpload, 0)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	return
+}
+
+// Type is the message type.
+func (m AcceptUploadMessage) Type() uint8 {
+	return MessageAcceptUpload
+}
+
+func (m AcceptUploadMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[accept-upload]\n")
+	b.WriteString(m.Header.String())
+	return b.String()
+}
+
+// QueueRankMessage message is sent by the uploading client when the downloading client is queued,
+// with its position in the upload queue.
+type QueueRankMessage struct {
+	message
+	Rank uint32
+}
+
+// Encode encodes the message to binary data.
+func (m *QueueRankMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageQueueRank); err != nil {
+		return
+	}
+	binary.Write(buf, binary.LittleEndian, m.Rank)
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *QueueRankMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageQueueRank, 4)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	m.Rank = binary.LittleEndian.Uint32(data[HeaderLength+1:])
+	return
+}
+
+// Type is the message type.
+func (m QueueRankMessage) Type() uint8 {
+	return MessageQueueRank
+}
+
+func (m QueueRankMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[queue-rank]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "rank: %d", m.Rank)
+	return b.String()
+}
+
+// CancelTransferMessage message is sent by the downloading client to release its upload slot or leave the queue.
+type CancelTransferMessage struct {
+	message
+}
+
+// Encode encodes the message to binary data.
+func (m *CancelTransferMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageCancelTransfer); err != nil {
+		return
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *CancelTransferMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageCancelTransfer, 0)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	return
+}
+
+// Type is the message type.
+func (m CancelTransferMessage) Type() uint8 {
+	return MessageCancelTransfer
+}
+
+func (m CancelTransferMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[cancel-transfer]\n")
+	b.WriteString(m.Header.String())
+	return b.String()
+}
+
+// OutOfPartsMessage message is sent by the uploading client when it ends the upload session,
+// e.g. the downloading client has received its share of data.
+type OutOfPartsMessage struct {
+	message
+}
+
+// Encode encodes the message to binary data.
+func (m *OutOfPartsMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageOutOfParts); err != nil {
+		return
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *OutOfPartsMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageOutOfParts, 0)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	return
+}
+
+// Type is the message type.
+func (m OutOfPartsMessage) Type() uint8 {
+	return MessageOutOfParts
+}
+
+func (m OutOfPartsMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[out-of-parts]\n")
+	b.WriteString(m.Header.String())
+	return b.String()
+}
+
+// PartRange is a byte range of a file, End is exclusive.
+// An empty range (Start == End) is an unused entry of a part request.
+type PartRange struct {
+	Start uint64
+	End   uint64
+}
+
+// RequestPartsMessage message is sent by the downloading client to request up to 3 byte ranges of a file,
+// the uploading client answers with a SendingPartMessage for each range.
+type RequestPartsMessage struct {
+	message
+	Hash   [16]byte
+	Ranges [3]PartRange
+}
+
+// Encode encodes the message to binary data, the offsets must fit in 32 bits.
+func (m *RequestPartsMessage) Encode() (data []byte, err error) {
+	return m.encode(m.Header, MessageRequestParts, false)
+}
+
+// encode encodes the message as message type mType, with 64-bit offsets if large is true.
+func (m *RequestPartsMessage) encode(header Header, mType uint8, large bool) (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, header, mType); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+	for _, r := range m.Ranges {
+		if err = writeOffset(buf, r.Start, large); err != nil {
+			return
+		}
+	}
+	for _, r := range m.Ranges {
+		if err = writeOffset(buf, r.End, large); err != nil {
+			return
+		}
+	}
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *RequestPartsMessage) Decode(data []byte) (err error) {
+	return m.decode(data, MessageRequestParts, false)
+}
+
+func (m *RequestPartsMessage) decode(data []byte, mType uint8, large bool) (err error) {
+	size := offsetSize(large)
+	header, _, err := decodeMessageHeader(data, mType, 16+3*size*2)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	pos := HeaderLength + 1
+	copy(m.Hash[:], data[pos:pos+16])
+	pos += 16
+	for i := range m.Ranges {
+		m.Ranges[i].Start = readOffset(data[pos:], large)
+		pos += size
+	}
+	for i := range m.Ranges {
+		m.Ranges[i].End = readOffset(data[pos:], large)
+		pos += size
+	}
+	return
+}
+
+// Type is the message type.
+func (m RequestPartsMessage) Type() uint8 {
+	return MessageRequestParts
+}
+
+func (m RequestPartsMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[request-parts]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X", m.Hash)
+	for i, r := range m.Ranges {
+		fmt.Fprintf(&b, "\n%d - %d-%d", i, r.Start, r.End)
+	}
+	return b.String()
+}
+
+// SendingPartMessage message is sent by the uploading client with the data of a requested byte range.
+type SendingPartMessage struct {
+	message
+	Hash [16]byte
+	// The byte range of the data, End is exclusive.
+	Start uint64
+	End   uint64
+	Data  []byte
+}
+
+// Encode encodes the message to binary data, the offsets must fit in 32 bits.
+func (m *SendingPartMessage) Encode() (data []byte, err error) {
+	return m.encode(m.Header, MessageSendingPart, false)
+}
+
+// encode encodes the message as message type mType, with 64-bit offsets if large is true.
+func (m *SendingPartMessage) encode(header Header, mType uint8, large bool) (data []byte, err error) {
+	if m.Start > m.End || m.End-m.Start != uint64(len(m.Data)) {
+		err = errors.New("part range does not match the data")
+		return
+	}
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, header, mType); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+	if err = writeOffset(buf, m.Start, large); err != nil {
+		return
+	}
+	if err = writeOffset(buf, m.End, large); err != nil {
+		return
+	}
+	buf.Write(m.Data)
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *SendingPartMessage) Decode(data []byte) (err error) {
+	return m.decode(data, MessageSendingPart, false)
+}
+
+func (m *SendingPartMessage) decode(data []byte, mType uint8, large bool) (err error) {
+	size := offsetSize(large)
+	header, end, err := decodeMessageHeader(data, mType, 16+size*2)
+	if err != nil {
+		return
+	}
+	pos := HeaderLength + 1
+	copy(m.Hash[:], data[pos:pos+16])
+	pos += 16
+	start := readOffset(data[pos:], large)
+	pos += size
+	stop := readOffset(data[pos:], large)
+	pos += size
+	if start > stop || uint64(end-pos) != stop-start {
+		return decodeError(mType, pos, fmt.Sprintf("part range %d-%d", start, stop), ErrShortBuffer)
+	}
+	m.Header = header
+	m.Start, m.End = start, stop
+	m.Data = append([]byte(nil), data[pos:end]...)
+	return
+}
+
+// Type is the message type.
+func (m SendingPartMessage) Type() uint8 {
+	return MessageSendingPart
+}
+
+func (m SendingPartMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[sending-part]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, range: %d-%d", m.Hash, m.Start, m.End)
+	return b.String()
+}
+
+// offsetSize returns the size of a file offset, 8 bytes if large is true or 4 bytes otherwise.
+func offsetSize(large bool) int {
+	if large {
+		return 8
+	}
+	return 4
+}
+
+// writeOffset writes the file offset v to buf as uint64 if large is true, or as uint32 otherwise.
+func writeOffset(buf *bytes.Buffer, v uint64, large bool) error {
+	if large {
+		return binary.Write(buf, binary.LittleEndian, v)
+	}
+	if v > math.MaxUint32 {
+		return ErrFileTooLarge
+	}
+	return binary.Write(buf, binary.LittleEndian, uint32(v))
+}
+
+// readOffset reads a file offset written by writeOffset from b.
+func readOffset(b []byte, large bool) uint64 {
+	if large {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return uint64(binary.LittleEndian.Uint32(b))
+}
+
+// EndOfDownloadMessage message is sent by the downloading client when it has completed the file.
+type EndOfDownloadMessage struct {
+	message
+	Hash [16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *EndOfDownloadMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeMessageHeader(buf, m.Header, MessageEndOfDownload); err != nil {
+		return
+	}
+	buf.Write(m.Hash[:])
+
+	data = messageData(buf)
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *EndOfDownloadMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageEndOfDownload, 16)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	copy(m.Hash[:], data[HeaderLength+1:])
+	return
+}
+
+// Type is the message type.
+func (m EndOfDownloadMessage) Type() uint8 {
+	return MessageEndOfDownload
+}
+
+func (m EndOfDownloadMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[end-of-download]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X", m.Hash)
+	return b.String()
+}
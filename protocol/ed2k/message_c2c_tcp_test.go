@@ -0,0 +1,125 @@
+package ed2k
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// tcpMessage returns the TCP message of protocol proto with the type and payload.
+func tcpMessage(proto, mType uint8, payload ...byte) []byte {
+	data := []byte{proto, 0, 0, 0, 0, mType}
+	data = append(data, payload...)
+	binary.LittleEndian.PutUint32(data[1:5], uint32(len(data)-HeaderLength))
+	return data
+}
+
+func TestCCTCPMessage(t *testing.T) {
+	hash := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	with := func(b ...byte) []byte {
+		return append(append([]byte{}, hash...), b...)
+	}
+
+	testCases := [][]byte{
+		tcpMessage(ProtoEDonkey, MessageHelloAnswer, with(
+			1, 0, 0, 0, 0x36, 0x12, 1, 0, 0, 0,
+			TagStr1|0x80, TagName, 'a',
+			0, 0, 0, 0, 0, 0)...),
+		tcpMessage(ProtoEDonkey, MessageFileRequest, hash...),
+		tcpMessage(ProtoEDonkey, MessageFileRequestAnswer, with(3, 0, 'a', 'b', 'c')...),
+		tcpMessage(ProtoEDonkey, MessageSetRequestedFileID, hash...),
+		tcpMessage(ProtoEDonkey, MessageFileStatus, with(0, 0)...),
+		tcpMessage(ProtoEDonkey, MessageFileStatus, with(10, 0, 0x81, 0x02)...),
+		tcpMessage(ProtoEDonkey, MessageNoSuchFile, hash...),
+		tcpMessage(ProtoEDonkey, MessageHashSetRequest, hash...),
+		tcpMessage(ProtoEDonkey, MessageHashSetAnswer, with(append([]byte{2, 0}, with(hash...)...)...)...),
+		tcpMessage(ProtoEDonkey, MessageStartUploadRequest, hash...),
+		tcpMessage(ProtoEDonkey, MessageAcceptUpload),
+		tcpMessage(ProtoEDonkey, MessageQueueRank, 10, 0, 0, 0),
+		tcpMessage(ProtoEDonkey, MessageCancelTransfer),
+		tcpMessage(ProtoEDonkey, MessageOutOfParts),
+		tcpMessage(ProtoEDonkey, MessageRequestParts, with(
+			0, 0, 0, 0, 0, 0x28, 0, 0, 0, 0, 0, 0,
+			0, 0x28, 0, 0, 0, 0x50, 0, 0, 0, 0, 0, 0)...),
+		tcpMessage(ProtoEDonkey, MessageSendingPart, with(0, 0x28, 0, 0, 3, 0x28, 0, 0, 'a', 'b', 'c')...),
+		tcpMessage(ProtoEDonkey, MessageEndOfDownload, hash...),
+	}
+
+	for i, tc := range testCases {
+		m, err := ReadMessage(bytes.NewReader(tc), CCTCPMessage)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if m.Type() != tc[5] {
+			t.Errorf("%d: type %#x, want %#x", i, m.Type(), tc[5])
+		}
+		if _, ok := m.(*RawMessage); ok {
+			t.Errorf("%d: message %#x not registered", i, tc[5])
+		}
+		b, err := m.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(b, tc) {
+			t.Errorf("%d: %s\n%# x\n%# x", i, m, b, tc)
+		}
+	}
+}
+
+func TestFileStatusMessage(t *testing.T) {
+	m := &FileStatusMessage{}
+	if err := m.Decode(tcpMessage(ProtoEDonkey, MessageFileStatus, make([]byte, 16+2)...)); err != nil {
+		t.Fatal(err)
+	}
+	if !m.Complete() {
+		t.Error("file status without parts is not complete")
+	}
+
+	payload := append(make([]byte, 16), 10, 0, 0x81, 0x02)
+	if err := m.Decode(tcpMessage(ProtoEDonkey, MessageFileStatus, payload...)); err != nil {
+		t.Fatal(err)
+	}
+	want := []bool{true, false, false, false, false, false, false, true, false, true}
+	if len(m.Parts) != len(want) || m.Complete() {
+		t.Fatalf("parts %v, want %v", m.Parts, want)
+	}
+	for i := range want {
+		if m.Parts[i] != want[i] {
+			t.Errorf("part %d: %v, want %v", i, m.Parts[i], want[i])
+		}
+	}
+}
+
+func TestCCTCPMessageDecode(t *testing.T) {
+	invalid := []Message{
+		&FileRequestMessage{},
+		&FileRequestAnswerMessage{},
+		&FileStatusMessage{},
+		&HashSetAnswerMessage{},
+		&QueueRankMessage{},
+		&RequestPartsMessage{},
+		&SendingPartMessage{},
+	}
+	for i, m := range invalid {
+		data := tcpMessage(ProtoEDonkey, m.Type(), append(make([]byte, 16), 0xFF, 0xFF, 0, 0)...)
+		if err := m.Decode(data[:HeaderLength+1]); err == nil {
+			t.Errorf("%d: short message decoded: %v", i, m)
+		}
+		if m.Type() == MessageFileRequest || m.Type() == MessageQueueRank {
+			continue
+		}
+		// a count or range pointing past the end of the message.
+		if err := m.Decode(data); err == nil {
+			t.Errorf("%d: invalid message decoded: %v", i, m)
+		}
+	}
+
+	if err := (&AcceptUploadMessage{}).Decode(tcpMessage(ProtoEDonkey, MessageCancelTransfer)); !errors.Is(err, ErrWrongMessageType) {
+		t.Errorf("wrong message type decoded: %v", err)
+	}
+
+	if _, err := (&SendingPartMessage{Start: 10, End: 20, Data: []byte{1}}).Encode(); err == nil {
+		t.Error("sending part with wrong range encoded")
+	}
+}
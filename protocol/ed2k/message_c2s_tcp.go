@@ -6,6 +6,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math"
 	"net"
 	"strings"
 )
@@ -17,7 +18,7 @@ type LoginMessage struct {
 	ClientID ClientID
 	// The TCP port used by the client, configurable.
 	Port uint16
-	Tags []Tag
+	Tags Tags
 }
 
 // Encode encodes the message to binary data.
@@ -51,21 +52,16 @@ func (m *LoginMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *LoginMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *LoginMessage) decode(data []byte, d *TagDecoder) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageLoginRequest, 16+4+2+4)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+1+16+4+2+4 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageLoginRequest {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 	copy(m.UID[:], data[pos:pos+16])
 
 	pos += 16
@@ -78,14 +74,7 @@ func (m *LoginMessage) Decode(data []byte) (err error) {
 	tagCount := binary.LittleEndian.Uint32(data[pos : pos+4])
 
 	pos += 4
-	r := bytes.NewReader(data[pos:])
-	for i := 0; i < int(tagCount); i++ {
-		tag, err := ReadTag(r)
-		if err != nil {
-			return err
-		}
-		m.Tags = append(m.Tags, tag)
-	}
+	m.Tags, _, err = readTags(d, data, pos, end, tagCount, MessageLoginRequest)
 	return
 }
 
@@ -140,25 +129,16 @@ func (m *ServerMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *ServerMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	header, end, err := decodeMessageHeader(data, MessageServerMessage, 2)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+3 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageServerMessage {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 	size := binary.LittleEndian.Uint16(data[pos : pos+2])
 	pos += 2
-	if len(data) < pos+int(size) {
-		return ErrShortBuffer
+	if end < pos+int(size) {
+		return decodeError(MessageServerMessage, pos, "message text", ErrShortBuffer)
 	}
 	m.Messages = string(data[pos : pos+int(size)])
 	return
@@ -183,7 +163,8 @@ func (m ServerMessage) String() string {
 type IDChangeMessage struct {
 	message
 	ClientID ClientID
-	// Currently only 1 bit (the LSB) has meaning, setting it to 1 signals that the server supports compression.
+	// The server TCP flags, ServerFlag* bits, the LSB signals that the server supports compression.
+	// See Capabilities.
 	Bitmap uint32
 }
 
@@ -210,21 +191,12 @@ func (m *IDChangeMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *IDChangeMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	header, _, err := decodeMessageHeader(data, MessageIDChange, 8)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+9 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageIDChange {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 	m.ClientID = ClientID(binary.LittleEndian.Uint32(data[pos : pos+4]))
 	pos += 4
 	m.Bitmap = binary.LittleEndian.Uint32(data[pos : pos+4])
@@ -283,31 +255,19 @@ func (m *OfferFilesMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *OfferFilesMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *OfferFilesMessage) decode(data []byte, d *TagDecoder) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageOfferFiles, 4)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+5 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageOfferFiles {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 	fileCount := binary.LittleEndian.Uint32(data[pos : pos+4])
 	pos += 4
-	r := bytes.NewReader(data[pos:])
-	for i := 0; i < int(fileCount); i++ {
-		file, err := ReadFile(r)
-		if err != nil {
-			return err
-		}
-		m.Files = append(m.Files, *file)
-	}
+	m.Files, _, err = readFiles(d, data, pos, end, fileCount, MessageOfferFiles)
 	return
 }
 
@@ -357,19 +317,10 @@ func (m *GetServerListMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *GetServerListMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	header, _, err := decodeMessageHeader(data, MessageGetServerList, 0)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+1 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageGetServerList {
-		return ErrWrongMessageType
-	}
 	m.Header = header
 
 	return
@@ -427,28 +378,20 @@ func (m *ServerListMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *ServerListMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	header, end, err := decodeMessageHeader(data, MessageServerList, 1)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+2 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageServerList {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 
 	count := int(data[pos])
 	pos++
-	if len(data) < pos+count*6 {
-		return ErrShortBuffer
+	if end < pos+count*6 {
+		return decodeError(MessageServerList, pos, "server count", ErrShortBuffer)
 	}
 
+	m.Servers = nil
 	for i := 0; i < count; i++ {
 		m.Servers = append(m.Servers,
 			&net.TCPAddr{
@@ -512,21 +455,12 @@ func (m *ServerStatusMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *ServerStatusMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	header, _, err := decodeMessageHeader(data, MessageServerStatus, 8)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+9 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageServerStatus {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 
 	m.UserCount = binary.LittleEndian.Uint32(data[pos : pos+4])
 	pos += 4
@@ -561,7 +495,7 @@ type ServerIdentMessage struct {
 	// The TCP port on which the server listens.
 	Port uint16
 
-	Tags []Tag
+	Tags Tags
 }
 
 // Encode encodes the message to binary data.
@@ -593,21 +527,16 @@ func (m *ServerIdentMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *ServerIdentMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *ServerIdentMessage) decode(data []byte, d *TagDecoder) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageServerIdent, 16+4+2+4)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(m.Header.Size) ||
-		len(data) < pos+29 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageServerIdent {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 
 	pos += copy(m.Hash[:], data[pos:])
 	m.IP = binary.LittleEndian.Uint32(data[pos : pos+4])
@@ -616,14 +545,7 @@ func (m *ServerIdentMessage) Decode(data []byte) (err error) {
 	pos += 2
 	tagCount := binary.LittleEndian.Uint32(data[pos : pos+4])
 	pos += 4
-	r := bytes.NewReader(data[pos:])
-	for i := 0; i < int(tagCount); i++ {
-		tag, err := ReadTag(r)
-		if err != nil {
-			return err
-		}
-		m.Tags = append(m.Tags, tag)
-	}
+	m.Tags, _, err = readTags(d, data, pos, end, tagCount, MessageServerIdent)
 	return
 }
 
@@ -656,6 +578,10 @@ type SearchRequestMessage struct {
 
 // Encode encodes the message to binary data.
 func (m *SearchRequestMessage) Encode() (data []byte, err error) {
+	if m.Searcher == nil {
+		err = ErrEmptySearcher
+		return
+	}
 	buf := new(bytes.Buffer)
 	if _, err = m.Header.WriteTo(buf); err != nil {
 		return
@@ -676,8 +602,17 @@ func (m *SearchRequestMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *SearchRequestMessage) Decode(data []byte) (err error) {
-	// TODO: decode
-	return nil
+	header, end, err := decodeMessageHeader(data, MessageSearchRequest, 1)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	pos := HeaderLength + 1
+
+	if m.Searcher, err = ReadSearcher(bytes.NewReader(data[pos:end])); err != nil {
+		err = decodeError(MessageSearchRequest, pos, "search expression", err)
+	}
+	return
 }
 
 // Type is the message type.
@@ -699,6 +634,9 @@ func (m *SearchRequestMessage) String() string {
 type SearchResultMessage struct {
 	message
 	Files []File
+	// More is set if the server has more results, the client can request them with MoreResultMessage.
+	// It is sent as an optional trailing byte.
+	More bool
 }
 
 // Encode encodes the message to binary data.
@@ -715,6 +653,9 @@ func (m *SearchResultMessage) Encode() (data []byte, err error) {
 			return
 		}
 	}
+	if m.More {
+		buf.WriteByte(1)
+	}
 
 	data = buf.Bytes()
 	size := len(data) - HeaderLength
@@ -724,30 +665,24 @@ func (m *SearchResultMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *SearchResultMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *SearchResultMessage) decode(data []byte, d *TagDecoder) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageSearchResult, 4)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+5 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageSearchResult {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 	fileCount := binary.LittleEndian.Uint32(data[pos : pos+4])
 	pos += 4
-	r := bytes.NewReader(data[pos:])
-	for i := 0; i < int(fileCount); i++ {
-		file, err := ReadFile(r)
-		if err != nil {
-			return err
-		}
-		m.Files = append(m.Files, *file)
+	if m.Files, pos, err = readFiles(d, data, pos, end, fileCount, MessageSearchResult); err != nil {
+		return
+	}
+	m.More = false
+	if pos < end {
+		m.More = data[pos] != 0
 	}
 	return
 }
@@ -769,6 +704,49 @@ func (m SearchResultMessage) String() string {
 		}
 
 	}
+	fmt.Fprintf(&b, "more: %v", m.More)
+	return b.String()
+}
+
+// MoreResultMessage message sent from the client to the server requesting more results of the last search request.
+type MoreResultMessage struct {
+	message
+}
+
+// Encode encodes the message to binary data.
+func (m *MoreResultMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if _, err = m.Header.WriteTo(buf); err != nil {
+		return
+	}
+	buf.WriteByte(MessageMoreResult)
+
+	data = buf.Bytes()
+	size := len(data) - HeaderLength
+	binary.LittleEndian.PutUint32(data[1:5], uint32(size)) // message size
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *MoreResultMessage) Decode(data []byte) (err error) {
+	header, _, err := decodeMessageHeader(data, MessageMoreResult, 0)
+	if err != nil {
+		return
+	}
+	m.Header = header
+
+	return
+}
+
+// Type is the message type.
+func (m MoreResultMessage) Type() uint8 {
+	return MessageMoreResult
+}
+
+func (m MoreResultMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[more-result]\n")
+	b.WriteString(m.Header.String())
 	return b.String()
 }
 
@@ -776,18 +754,29 @@ func (m SearchResultMessage) String() string {
 type GetSourcesMessage struct {
 	message
 	Hash [16]byte
-	Size uint32
+	// Size of the file, sizes above 4GB are only supported by servers with CapLargeFiles.
+	Size uint64
 }
 
 // Encode encodes the message to binary data.
 func (m *GetSourcesMessage) Encode() (data []byte, err error) {
+	return m.encode(MessageGetSources)
+}
+
+func (m *GetSourcesMessage) encode(mType uint8) (data []byte, err error) {
 	buf := new(bytes.Buffer)
 	if _, err = m.Header.WriteTo(buf); err != nil {
 		return
 	}
-	buf.WriteByte(MessageGetSources)
+	buf.WriteByte(mType)
 	buf.Write(m.Hash[:])
-	binary.Write(buf, binary.LittleEndian, m.Size)
+	if m.Size > math.MaxUint32 {
+		// large file: zero 32-bit size followed by the 64-bit size.
+		binary.Write(buf, binary.LittleEndian, uint32(0))
+		binary.Write(buf, binary.LittleEndian, m.Size)
+	} else {
+		binary.Write(buf, binary.LittleEndian, uint32(m.Size))
+	}
 
 	data = buf.Bytes()
 	size := len(data) - HeaderLength
@@ -797,25 +786,24 @@ func (m *GetSourcesMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *GetSourcesMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	return m.decode(data, MessageGetSources)
+}
+
+func (m *GetSourcesMessage) decode(data []byte, mType uint8) (err error) {
+	header, _, err := decodeMessageHeader(data, mType, 16+4)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+21 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageGetSources {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 
 	copy(m.Hash[:], data[pos:pos+16])
 	pos += 16
-	m.Size = binary.LittleEndian.Uint32(data[pos : pos+4])
+	m.Size = uint64(binary.LittleEndian.Uint32(data[pos : pos+4]))
+	pos += 4
+	if m.Size == 0 && int(header.Size) >= 29 {
+		m.Size = binary.LittleEndian.Uint64(data[pos : pos+8])
+	}
 
 	return
 }
@@ -834,6 +822,37 @@ func (m GetSourcesMessage) String() string {
 	return b.String()
 }
 
+// GetSourcesOBFUMessage message sent from the client to the server requesting sources for a file,
+// the same as GetSourcesMessage but the server answers with FoundSourcesOBFUMessage.
+// It is sent only to servers supporting obfuscation.
+type GetSourcesOBFUMessage struct {
+	GetSourcesMessage
+}
+
+// Encode encodes the message to binary data.
+func (m *GetSourcesOBFUMessage) Encode() (data []byte, err error) {
+	return m.encode(MessageGetSourcesOBFU)
+}
+
+// Decode decodes the message from binary data.
+func (m *GetSourcesOBFUMessage) Decode(data []byte) (err error) {
+	return m.decode(data, MessageGetSourcesOBFU)
+}
+
+// Type is the message type.
+func (m GetSourcesOBFUMessage) Type() uint8 {
+	return MessageGetSourcesOBFU
+}
+
+func (m GetSourcesOBFUMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[get-sources-obfu]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X, size: %d", m.Hash, m.Size)
+	return b.String()
+}
+
 // FoundSourcesMessage message sent from the server to the client with sources (other clients) for a file requested by the client for a file.
 type FoundSourcesMessage struct {
 	message
@@ -843,13 +862,17 @@ type FoundSourcesMessage struct {
 
 // Encode encodes the message to binary data.
 func (m *FoundSourcesMessage) Encode() (data []byte, err error) {
+	if len(m.Sources) > math.MaxUint8 {
+		err = fmt.Errorf("too many sources: %d", len(m.Sources))
+		return
+	}
 	buf := new(bytes.Buffer)
 	if _, err = m.Header.WriteTo(buf); err != nil {
 		return
 	}
 	buf.WriteByte(MessageFoundSources)
 	buf.Write(m.Hash[:])
-	binary.Write(buf, binary.LittleEndian, uint32(len(m.Sources)))
+	buf.WriteByte(uint8(len(m.Sources)))
 
 	for _, source := range m.Sources {
 		if source == nil {
@@ -870,31 +893,23 @@ func (m *FoundSourcesMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *FoundSourcesMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	header, end, err := decodeMessageHeader(data, MessageFoundSources, 16+1)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+18 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageFoundSources {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 
 	copy(m.Hash[:], data[pos:pos+16])
 	pos += 16
 
 	count := int(data[pos])
 	pos++
-	if len(data) < pos+count*6 {
-		return ErrShortBuffer
+	if end < pos+count*6 {
+		return decodeError(MessageFoundSources, pos, "source count", ErrShortBuffer)
 	}
 
+	m.Sources = nil
 	for i := 0; i < count; i++ {
 		m.Sources = append(m.Sources,
 			&net.TCPAddr{
@@ -925,6 +940,134 @@ func (m FoundSourcesMessage) String() string {
 	return b.String()
 }
 
+// Source crypt options, sent in FoundSourcesOBFUMessage.
+const (
+	SourceCryptSupport = 0x01 // the source supports obfuscation.
+	SourceCryptRequest = 0x02 // the source requests obfuscation.
+	SourceCryptRequire = 0x04 // the source requires obfuscation.
+	SourceUserHash     = 0x80 // the user hash of the source follows.
+)
+
+// CryptSource is a source with its obfuscation settings.
+type CryptSource struct {
+	Addr *net.TCPAddr
+	// Crypt options of the source, SourceCrypt* flags.
+	CryptOptions uint8
+	// The user hash of the source, only sent if CryptOptions has SourceUserHash set.
+	// The user hash is the key for the obfuscated connection to the source.
+	UserHash UID
+}
+
+func (s CryptSource) String() string {
+	if s.CryptOptions&SourceUserHash != 0 {
+		return fmt.Sprintf("%v(%#02x %s)", s.Addr, s.CryptOptions, s.UserHash)
+	}
+	return fmt.Sprintf("%v(%#02x)", s.Addr, s.CryptOptions)
+}
+
+// FoundSourcesOBFUMessage message sent from the server to the client as a reply to a get sources OBFU request.
+// Unlike FoundSourcesMessage, each source has its crypt options and optional user hash.
+type FoundSourcesOBFUMessage struct {
+	message
+	Hash    [16]byte
+	Sources []CryptSource
+}
+
+// Encode encodes the message to binary data.
+func (m *FoundSourcesOBFUMessage) Encode() (data []byte, err error) {
+	if len(m.Sources) > math.MaxUint8 {
+		err = fmt.Errorf("too many sources: %d", len(m.Sources))
+		return
+	}
+	buf := new(bytes.Buffer)
+	if _, err = m.Header.WriteTo(buf); err != nil {
+		return
+	}
+	buf.WriteByte(MessageFoundSourcesOBFU)
+	buf.Write(m.Hash[:])
+	buf.WriteByte(uint8(len(m.Sources)))
+
+	for _, source := range m.Sources {
+		addr := source.Addr
+		if addr == nil {
+			addr = &net.TCPAddr{
+				IP:   net.IPv4zero,
+				Port: 0,
+			}
+		}
+		buf.Write(addr.IP.To4())
+		binary.Write(buf, binary.LittleEndian, uint16(addr.Port))
+		buf.WriteByte(source.CryptOptions)
+		if source.CryptOptions&SourceUserHash != 0 {
+			buf.Write(source.UserHash[:])
+		}
+	}
+
+	data = buf.Bytes()
+	size := len(data) - HeaderLength
+	binary.LittleEndian.PutUint32(data[1:5], uint32(size)) // message size
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *FoundSourcesOBFUMessage) Decode(data []byte) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageFoundSourcesOBFU, 16+1)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	pos := HeaderLength + 1
+
+	copy(m.Hash[:], data[pos:pos+16])
+	pos += 16
+
+	count := int(data[pos])
+	pos++
+
+	m.Sources = nil
+	for i := 0; i < count; i++ {
+		if end < pos+7 {
+			return decodeError(MessageFoundSourcesOBFU, pos, fmt.Sprintf("source %d", i), ErrShortBuffer)
+		}
+		source := CryptSource{
+			Addr: &net.TCPAddr{
+				IP:   net.IPv4(data[pos], data[pos+1], data[pos+2], data[pos+3]),
+				Port: int(binary.LittleEndian.Uint16(data[pos+4 : pos+6])),
+			},
+			CryptOptions: data[pos+6],
+		}
+		pos += 7
+		if source.CryptOptions&SourceUserHash != 0 {
+			if end < pos+16 {
+				return decodeError(MessageFoundSourcesOBFU, pos, fmt.Sprintf("source %d user hash", i), ErrShortBuffer)
+			}
+			pos += copy(source.UserHash[:], data[pos:pos+16])
+		}
+		m.Sources = append(m.Sources, source)
+	}
+
+	return
+}
+
+// Type is the message type.
+func (m FoundSourcesOBFUMessage) Type() uint8 {
+	return MessageFoundSourcesOBFU
+}
+
+func (m FoundSourcesOBFUMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[found-sources-obfu]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "hash: %X\nsources:\n", m.Hash)
+	var ss []string
+	for _, src := range m.Sources {
+		ss = append(ss, src.String())
+	}
+	b.WriteString(strings.Join(ss, ","))
+	return b.String()
+}
+
 // CallbackRequestMessage message sent from the client to the server, requesting another client to call back - e.g.
 // connect to the requesting client. The message is sent by a client that has a high ID who wishes to connect to a low ID client.
 type CallbackRequestMessage struct {
@@ -949,21 +1092,12 @@ func (m *CallbackRequestMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *CallbackRequestMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	header, _, err := decodeMessageHeader(data, MessageCallbackRequest, 4)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+5 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageCallbackRequest {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 
 	m.ClientID = ClientID(binary.LittleEndian.Uint32(data[pos : pos+4]))
 	return
@@ -1010,25 +1144,16 @@ func (m *CallbackRequestedMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *CallbackRequestedMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	header, _, err := decodeMessageHeader(data, MessageCallbackRequested, 6)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+7 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageCallbackRequested {
-		return ErrWrongMessageType
-	}
 	m.Header = header
-	pos++
+	pos := HeaderLength + 1
 
 	m.IP = binary.LittleEndian.Uint32(data[pos : pos+4])
 	pos += 4
-	m.Port = binary.BigEndian.Uint16(data[pos : pos+2])
+	m.Port = binary.LittleEndian.Uint16(data[pos : pos+2])
 	return
 }
 
@@ -1067,19 +1192,10 @@ func (m *CallbackFailedMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *CallbackFailedMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	header, _, err := decodeMessageHeader(data, MessageCallbackFailed, 0)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+1 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageCallbackFailed {
-		return ErrWrongMessageType
-	}
 	m.Header = header
 	return
 }
@@ -1117,19 +1233,10 @@ func (m *RejectedMessage) Encode() (data []byte, err error) {
 
 // Decode decodes the message from binary data.
 func (m *RejectedMessage) Decode(data []byte) (err error) {
-	header := Header{}
-	err = header.Decode(data)
+	header, _, err := decodeMessageHeader(data, MessageRejected, 0)
 	if err != nil {
 		return
 	}
-	pos := HeaderLength
-	if len(data) < pos+int(header.Size) ||
-		len(data) < pos+1 {
-		return ErrShortBuffer
-	}
-	if data[5] != MessageRejected {
-		return ErrWrongMessageType
-	}
 	m.Header = header
 	return
 }
@@ -1145,3 +1252,171 @@ func (m RejectedMessage) String() string {
 	b.WriteString(m.Header.String())
 	return b.String()
 }
+
+// SearchUserMessage message sent from the client to the server searching for users by name.
+// The search expression has the same format as in search request, usually a single name term.
+type SearchUserMessage struct {
+	message
+	Searcher FileSearcher
+}
+
+// Encode encodes the message to binary data.
+func (m *SearchUserMessage) Encode() (data []byte, err error) {
+	if m.Searcher == nil {
+		err = ErrEmptySearcher
+		return
+	}
+	buf := new(bytes.Buffer)
+	if _, err = m.Header.WriteTo(buf); err != nil {
+		return
+	}
+	buf.WriteByte(MessageSearchUser)
+
+	b, err := m.Searcher.Encode()
+	if err != nil {
+		return
+	}
+	buf.Write(b)
+
+	data = buf.Bytes()
+	size := len(data) - HeaderLength
+	binary.LittleEndian.PutUint32(data[1:5], uint32(size)) // message size
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *SearchUserMessage) Decode(data []byte) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageSearchUser, 1)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	pos := HeaderLength + 1
+
+	if m.Searcher, err = ReadSearcher(bytes.NewReader(data[pos:end])); err != nil {
+		err = decodeError(MessageSearchUser, pos, "search expression", err)
+	}
+	return
+}
+
+// Type is the message type.
+func (m *SearchUserMessage) Type() uint8 {
+	return MessageSearchUser
+}
+
+func (m *SearchUserMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[search-user]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprint(&b, m.Searcher)
+	return b.String()
+}
+
+// User is a single user entry of user list.
+type User struct {
+	UID      UID
+	ClientID ClientID
+	// The client's TCP port.
+	Port uint16
+	// User tags: nick name, etc.
+	Tags Tags
+}
+
+// Name returns the nick name of the user.
+func (u *User) Name() string {
+	name, _ := u.Tags.String(CTName)
+	return name
+}
+
+// UserListMessage message sent from the server to the client as a reply to a search user request.
+type UserListMessage struct {
+	message
+	Users []User
+}
+
+// Encode encodes the message to binary data.
+func (m *UserListMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if _, err = m.Header.WriteTo(buf); err != nil {
+		return
+	}
+	buf.WriteByte(MessageUserList)
+	binary.Write(buf, binary.LittleEndian, uint32(len(m.Users)))
+
+	for _, user := range m.Users {
+		buf.Write(user.UID[:])
+		binary.Write(buf, binary.LittleEndian, user.ClientID)
+		binary.Write(buf, binary.LittleEndian, user.Port)
+		binary.Write(buf, binary.LittleEndian, uint32(len(user.Tags)))
+		for _, tag := range user.Tags {
+			if _, err = tag.WriteTo(buf); err != nil {
+				return
+			}
+		}
+	}
+
+	data = buf.Bytes()
+	size := len(data) - HeaderLength
+	binary.LittleEndian.PutUint32(data[1:5], uint32(size)) // message size
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *UserListMessage) Decode(data []byte) (err error) {
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *UserListMessage) decode(data []byte, d *TagDecoder) (err error) {
+	header, end, err := decodeMessageHeader(data, MessageUserList, 4)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	pos := HeaderLength + 1
+	userCount := binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
+
+	m.Users = nil
+	for i := uint32(0); i < userCount; i++ {
+		if end < pos+16+4+2+4 {
+			return decodeError(MessageUserList, pos, fmt.Sprintf("user %d", i), ErrShortBuffer)
+		}
+		user := User{}
+		pos += copy(user.UID[:], data[pos:pos+16])
+		user.ClientID = ClientID(binary.LittleEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		user.Port = binary.LittleEndian.Uint16(data[pos : pos+2])
+		pos += 2
+		tagCount := binary.LittleEndian.Uint32(data[pos : pos+4])
+		pos += 4
+		if user.Tags, pos, err = readTags(d, data, pos, end, tagCount, MessageUserList); err != nil {
+			return
+		}
+		m.Users = append(m.Users, user)
+		// a raw tag holds the rest of the message.
+		if len(user.Tags) > 0 && isRawTag(user.Tags[len(user.Tags)-1]) {
+			break
+		}
+	}
+	return
+}
+
+// Type is the message type.
+func (m UserListMessage) Type() uint8 {
+	return MessageUserList
+}
+
+func (m UserListMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[user-list]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\nusers:\n")
+	for i, user := range m.Users {
+		fmt.Fprintf(&b, "user%d - %s %s:%d\n", i, user.UID, user.ClientID, user.Port)
+		for j, tag := range user.Tags {
+			fmt.Fprintf(&b, "tag%d - %v: %v\n", j, tag.Name(), tag.Value())
+		}
+	}
+	return b.String()
+}
@@ -2,6 +2,9 @@ package ed2k
 
 import (
 	"bytes"
+	"compress/zlib"
+	"errors"
+	"io"
 	"testing"
 )
 
@@ -940,3 +943,200 @@ func TestServerMessageDecode(t *testing.T) {
 
 	}
 }
+
+func TestGetSourcesMessage(t *testing.T) {
+	hash := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	testCases := []struct {
+		size uint64
+		out  []byte
+	}{
+		{
+			700 << 20,
+			append(append([]byte{ProtoEDonkey, 21, 0, 0, 0, MessageGetSources}, hash[:]...),
+				0, 0, 0xC0, 0x2B),
+		},
+		{
+			5 << 30,
+			append(append([]byte{ProtoEDonkey, 29, 0, 0, 0, MessageGetSources}, hash[:]...),
+				0, 0, 0, 0, 0, 0, 0, 0x40, 1, 0, 0, 0),
+		},
+	}
+
+	for i, tc := range testCases {
+		m := &GetSourcesMessage{message: message{Header: Header{Protocol: ProtoEDonkey}}, Hash: hash, Size: tc.size}
+		b, err := m.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(b, tc.out) {
+			t.Errorf("%d: %# x, want %# x", i, b, tc.out)
+		}
+		m2 := &GetSourcesMessage{}
+		if err := m2.Decode(tc.out); err != nil {
+			t.Fatal(i, err)
+		}
+		if m2.Hash != hash || m2.Size != tc.size {
+			t.Errorf("%d: decoded %v", i, m2)
+		}
+	}
+}
+
+func TestFoundSourcesMessage(t *testing.T) {
+	hash := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	uid := []byte{0x10, 0x11, 0x12, 0x13, 0x14, 14, 0x16, 0x17, 0x18, 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 111, 0x1F}
+	testCases := [][]byte{
+		append(append([]byte{ProtoEDonkey, 30, 0, 0, 0, MessageFoundSources}, hash...),
+			2, 192, 168, 1, 1, 0x36, 0x12, 10, 0, 0, 1, 0x37, 0x12),
+		append(append(append([]byte{ProtoEDonkey, 48, 0, 0, 0, MessageFoundSourcesOBFU}, hash...),
+			2, 192, 168, 1, 1, 0x36, 0x12, SourceCryptSupport|SourceCryptRequest,
+			10, 0, 0, 1, 0x37, 0x12, SourceCryptSupport|SourceCryptRequire|SourceUserHash), uid...),
+		append(append([]byte{ProtoEDonkey, 21, 0, 0, 0, MessageGetSourcesOBFU}, hash...), 0, 0, 0xC0, 0x2B),
+	}
+
+	for i, tc := range testCases {
+		m, err := ReadMessage(bytes.NewReader(tc), CSTCPMessage)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if m.Type() != tc[5] {
+			t.Errorf("%d: type %#x, want %#x", i, m.Type(), tc[5])
+		}
+		b, err := m.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(b, tc) {
+			t.Errorf("%d: %s\n%# x\n%# x", i, m, b, tc)
+		}
+	}
+
+	m := &FoundSourcesOBFUMessage{}
+	if err := m.Decode(testCases[1]); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Sources) != 2 || m.Sources[0].Addr.Port != 4662 || m.Sources[0].CryptOptions&SourceUserHash != 0 ||
+		!bytes.Equal(m.Sources[1].UserHash[:], uid) {
+		t.Errorf("unexpected sources %v", m.Sources)
+	}
+
+	// a user hash flag without the hash
+	short := append(append([]byte{ProtoEDonkey, 25, 0, 0, 0, MessageFoundSourcesOBFU}, hash...),
+		1, 192, 168, 1, 1, 0x36, 0x12, SourceUserHash)
+	if err := m.Decode(short); !errors.Is(err, ErrShortBuffer) {
+		t.Errorf("got %v, want %v", err, ErrShortBuffer)
+	}
+}
+
+func TestUserListMessage(t *testing.T) {
+	uid := []byte{0x10, 0x11, 0x12, 0x13, 0x14, 14, 0x16, 0x17, 0x18, 0x19, 0x1A, 0x1B, 0x1C, 0x1D, 111, 0x1F}
+	testCases := [][]byte{
+		{ProtoEDonkey, 6, 0, 0, 0, MessageSearchUser, SearchName, 2, 0, 'b', 'o'},
+		{ProtoEDonkey, 5, 0, 0, 0, MessageUserList, 0, 0, 0, 0},
+		append(append([]byte{ProtoEDonkey, 70, 0, 0, 0, MessageUserList, 2, 0, 0, 0},
+			append(uid,
+				1, 0, 0, 0, // client ID
+				0x36, 0x12, // port
+				1, 0, 0, 0, // tag count
+				TagStr3|0x80, byte(CTName), 'b', 'o', 'b')...),
+			append(uid,
+				192, 168, 1, 1, // client ID
+				0x37, 0x12, // port
+				2, 0, 0, 0, // tag count
+				TagStr3|0x80, byte(CTName), 'b', 'o', 'b',
+				TagUint8|0x80, byte(CTPort), 1)...),
+	}
+
+	for i, tc := range testCases {
+		m, err := ReadMessage(bytes.NewReader(tc), CSTCPMessage)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if m.Type() != tc[5] {
+			t.Errorf("%d: type %#x, want %#x", i, m.Type(), tc[5])
+		}
+		b, err := m.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(b, tc) {
+			t.Errorf("%d: %s\n%# x\n%# x", i, m, b, tc)
+		}
+	}
+
+	m := &UserListMessage{}
+	if err := m.Decode(testCases[2]); err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Users) != 2 || m.Users[0].Name() != "bob" || m.Users[0].Port != 4662 ||
+		m.Users[1].ClientID.String() != "192.168.1.1" || !bytes.Equal(m.Users[1].UID[:], uid) {
+		t.Errorf("unexpected users %v", m)
+	}
+
+	// user count larger than the entries
+	short := []byte{ProtoEDonkey, 5, 0, 0, 0, MessageUserList, 1, 0, 0, 0}
+	if err := m.Decode(short); err == nil {
+		t.Error("truncated user list decoded")
+	}
+}
+
+func TestSearchResultMore(t *testing.T) {
+	testCases := [][]byte{
+		{ProtoEDonkey, 5, 0, 0, 0, MessageSearchResult, 0, 0, 0, 0},
+		{ProtoEDonkey, 6, 0, 0, 0, MessageSearchResult, 0, 0, 0, 0, 1},
+		{ProtoEDonkey, 1, 0, 0, 0, MessageMoreResult},
+	}
+	for i, tc := range testCases {
+		m, err := ReadMessage(bytes.NewReader(tc), CSTCPMessage)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if result, ok := m.(*SearchResultMessage); ok && result.More != (len(tc) == 11) {
+			t.Errorf("%d: more %v", i, result.More)
+		}
+		b, err := m.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(b, tc) {
+			t.Errorf("%d: %s\n%# x\n%# x", i, m, b, tc)
+		}
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	testCases := []struct {
+		in     []byte
+		mType  uint8
+		offset int
+		err    error
+	}{
+		// a file count larger than the files sent.
+		{tcpMessage(ProtoEDonkey, MessageSearchResult, 0xFF, 0xFF, 0xFF, 0xFF, 1, 2, 3), MessageSearchResult, 10, ErrShortBuffer},
+		// a hash size other than 16.
+		{tcpMessage(ProtoEDonkey, MessageHello, append([]byte{0xFF}, make([]byte, 32)...)...), MessageHello, 6, ErrUserHashSize},
+		{tcpMessage(ProtoEDonkey, MessageHello, append([]byte{4}, make([]byte, 32)...)...), MessageHello, 6, ErrUserHashSize},
+		{tcpMessage(ProtoEDonkey, MessageLoginRequest, 1, 2), MessageLoginRequest, HeaderLength + 3, ErrShortBuffer},
+		// a tag count larger than the tags sent.
+		{tcpMessage(ProtoEDonkey, MessageServerIdent, append(make([]byte, 22), 2, 0, 0, 0, TagUint8|0x80, TagName, 1)...), MessageServerIdent, 35, ErrShortBuffer},
+		// a packed payload which is not zlib data.
+		{tcpMessage(ProtoPacked, MessageSearchResult, 1, 2, 3, 4), MessageSearchResult, HeaderLength + 1, zlib.ErrHeader},
+	}
+	for i, tc := range testCases {
+		_, err := ReadMessage(bytes.NewReader(tc.in), CSTCPMessage)
+		if tc.mType == MessageHello {
+			_, err = ReadMessage(bytes.NewReader(tc.in), CCTCPMessage)
+		}
+		var de *DecodeError
+		if !errors.As(err, &de) {
+			t.Fatalf("%d: got %v, want decode error", i, err)
+		}
+		if de.Type != tc.mType || de.Offset != tc.offset || !errors.Is(err, tc.err) {
+			t.Errorf("%d: got %v", i, err)
+		}
+	}
+
+	// a size larger than the data sent is not allocated up front.
+	if _, err := ReadMessage(bytes.NewReader([]byte{ProtoEDonkey, 0xFF, 0xFF, 0xFF, 0x7F, MessageSearchResult}), CSTCPMessage); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want %v", err, io.ErrUnexpectedEOF)
+	}
+}
@@ -0,0 +1,782 @@
+// Client Server UDP Messages
+
+package ed2k
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net"
+	"strings"
+)
+
+const (
+	// ServerDescChallengeMask is the low 16 bits of the server description request challenge.
+	// A server description response starting with it has the new form with challenge and tags.
+	ServerDescChallengeMask = 0xF0FF
+)
+
+func init() {
+	for opcode, fn := range map[uint8]func() Message{
+		MessageGlobalServerStatusRequest:  func() Message { return &GlobalServerStatusRequestMessage{} },
+		MessageGlobalServerStatusResponse: func() Message { return &GlobalServerStatusResponseMessage{} },
+		MessageGlobalSearchRequest:        func() Message { return &GlobalSearchRequestMessage{Version: 1} },
+		MessageGlobalSearchRequest2:       func() Message { return &GlobalSearchRequestMessage{Version: 2} },
+		MessageGlobalSearchRequest3:       func() Message { return &GlobalSearchRequestMessage{Version: 3} },
+		MessageGlobalSearchResult:         func() Message { return &GlobalSearchResultMessage{} },
+		MessageGlobalGetSources:           func() Message { return &GlobalGetSourcesMessage{} },
+		MessageGlobalGetSources2:          func() Message { return &GlobalGetSources2Message{} },
+		MessageGlobalFoundSources:         func() Message { return &GlobalFoundSourcesMessage{} },
+		MessageServerDescRequest:          func() Message { return &ServerDescRequestMessage{} },
+		MessageServerDescResponse:         func() Message { return &ServerDescResponseMessage{} },
+	} {
+		RegisterMessage(CSUDPMessage, ProtoEDonkey, opcode, fn)
+	}
+}
+
+// GlobalServerStatusRequestMessage message sent from the client to a server it is not connected to, requesting the server status.
+type GlobalServerStatusRequestMessage struct {
+	message
+	// A random value echoed back by the server in the response.
+	Challenge uint32
+}
+
+// Encode encodes the message to binary data.
+func (m *GlobalServerStatusRequestMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeDatagramHeader(buf, m.Header, MessageGlobalServerStatusRequest); err != nil {
+		return
+	}
+	binary.Write(buf, binary.LittleEndian, m.Challenge)
+
+	data = buf.Bytes()
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *GlobalServerStatusRequestMessage) Decode(data []byte) (err error) {
+	header, err := decodeDatagramHeader(data, MessageGlobalServerStatusRequest, 4)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	m.Challenge = binary.LittleEndian.Uint32(data[DatagramHeaderLength:])
+	return
+}
+
+// Type is the message type.
+func (m GlobalServerStatusRequestMessage) Type() uint8 {
+	return MessageGlobalServerStatusRequest
+}
+
+func (m GlobalServerStatusRequestMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[global-server-status-request]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "challenge: %#08x", m.Challenge)
+	return b.String()
+}
+
+// GlobalServerStatusResponseMessage message sent from the server to the client as a reply to a global server status request.
+// The fields following FileCount are optional and only sent by newer servers,
+// a zero value of a trailing optional field is not encoded.
+type GlobalServerStatusResponseMessage struct {
+	message
+	// The challenge of the request.
+	Challenge uint32
+	// The number of users currently logged in to the server.
+	UserCount uint32
+	// The number of files that this server is informed about.
+	FileCount uint32
+
+	MaxUsers uint32
+	// The soft and hard limits of files offered by a client.
+	SoftFiles uint32
+	HardFiles uint32
+	// Server UDP capability flags.
+	UDPFlags   uint32
+	LowIDUsers uint32
+	// The ports of the obfuscated protocol and the key for obfuscated UDP.
+	UDPObfuscationPort uint16
+	TCPObfuscationPort uint16
+	UDPKey             uint32
+}
+
+// Encode encodes the message to binary data.
+func (m *GlobalServerStatusResponseMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeDatagramHeader(buf, m.Header, MessageGlobalServerStatusResponse); err != nil {
+		return
+	}
+	binary.Write(buf, binary.LittleEndian, m.Challenge)
+	binary.Write(buf, binary.LittleEndian, m.UserCount)
+	binary.Write(buf, binary.LittleEndian, m.FileCount)
+
+	// the number of optional field groups to write.
+	ext := 0
+	switch {
+	case m.UDPObfuscationPort != 0 || m.TCPObfuscationPort != 0 || m.UDPKey != 0:
+		ext = 5
+	case m.LowIDUsers != 0:
+		ext = 4
+	case m.UDPFlags != 0:
+		ext = 3
+	case m.SoftFiles != 0 || m.HardFiles != 0:
+		ext = 2
+	case m.MaxUsers != 0:
+		ext = 1
+	}
+	if ext >= 1 {
+		binary.Write(buf, binary.LittleEndian, m.MaxUsers)
+	}
+	if ext >= 2 {
+		binary.Write(buf, binary.LittleEndian, m.SoftFiles)
+		binary.Write(buf, binary.LittleEndian, m.HardFiles)
+	}
+	if ext >= 3 {
+		binary.Write(buf, binary.LittleEndian, m.UDPFlags)
+	}
+	if ext >= 4 {
+		binary.Write(buf, binary.LittleEndian, m.LowIDUsers)
+	}
+	if ext >= 5 {
+		binary.Write(buf, binary.LittleEndian, m.UDPObfuscationPort)
+		binary.Write(buf, binary.LittleEndian, m.TCPObfuscationPort)
+		binary.Write(buf, binary.LittleEndian, m.UDPKey)
+	}
+
+	data = buf.Bytes()
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *GlobalServerStatusResponseMessage) Decode(data []byte) (err error) {
+	header, err := decodeDatagramHeader(data, MessageGlobalServerStatusResponse, 12)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	pos := DatagramHeaderLength
+
+	m.Challenge = binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
+	m.UserCount = binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
+	m.FileCount = binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
+
+	if len(data) >= pos+4 {
+		m.MaxUsers = binary.LittleEndian.Uint32(data[pos : pos+4])
+		pos += 4
+	}
+	if len(data) >= pos+8 {
+		m.SoftFiles = binary.LittleEndian.Uint32(data[pos : pos+4])
+		m.HardFiles = binary.LittleEndian.Uint32(data[pos+4 : pos+8])
+		pos += 8
+	}
+	if len(data) >= pos+4 {
+		m.UDPFlags = binary.LittleEndian.Uint32(data[pos : pos+4])
+		pos += 4
+	}
+	if len(data) >= pos+4 {
+		m.LowIDUsers = binary.LittleEndian.Uint32(data[pos : pos+4])
+		pos += 4
+	}
+	if len(data) >= pos+8 {
+		m.UDPObfuscationPort = binary.LittleEndian.Uint16(data[pos : pos+2])
+		m.TCPObfuscationPort = binary.LittleEndian.Uint16(data[pos+2 : pos+4])
+		m.UDPKey = binary.LittleEndian.Uint32(data[pos+4 : pos+8])
+	}
+	return
+}
+
+// Type is the message type.
+func (m GlobalServerStatusResponseMessage) Type() uint8 {
+	return MessageGlobalServerStatusResponse
+}
+
+func (m GlobalServerStatusResponseMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[global-server-status-response]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "challenge: %#08x, users: %d, files: %d, max users: %d, soft files: %d, hard files: %d\n",
+		m.Challenge, m.UserCount, m.FileCount, m.MaxUsers, m.SoftFiles, m.HardFiles)
+	fmt.Fprintf(&b, "udp flags: %#x, lowid users: %d, obfuscation ports: udp %d tcp %d, udp key: %#08x",
+		m.UDPFlags, m.LowIDUsers, m.UDPObfuscationPort, m.TCPObfuscationPort, m.UDPKey)
+	return b.String()
+}
+
+// GlobalSearchRequestMessage message sent from the client to a server it is not connected to, searching for files.
+// Version 1 (0x98) and 2 (0x92) carry only the search expression, version 2 is sent to servers supporting
+// the new tags. Version 3 (0x90) is sent to servers supporting large files and carries tags before the expression.
+type GlobalSearchRequestMessage struct {
+	message
+	// The version of the request: 1, 2 or 3, 0 means 1.
+	Version  int
+	Tags     Tags
+	Searcher FileSearcher
+}
+
+func (m *GlobalSearchRequestMessage) opcode() uint8 {
+	switch m.Version {
+	case 2:
+		return MessageGlobalSearchRequest2
+	case 3:
+		return MessageGlobalSearchRequest3
+	}
+	return MessageGlobalSearchRequest
+}
+
+// Encode encodes the message to binary data.
+func (m *GlobalSearchRequestMessage) Encode() (data []byte, err error) {
+	if m.Searcher == nil {
+		err = ErrEmptySearcher
+		return
+	}
+	buf := new(bytes.Buffer)
+	if err = writeDatagramHeader(buf, m.Header, m.opcode()); err != nil {
+		return
+	}
+	if m.Version == 3 {
+		binary.Write(buf, binary.LittleEndian, uint32(len(m.Tags)))
+		for _, tag := range m.Tags {
+			if _, err = tag.WriteTo(buf); err != nil {
+				return
+			}
+		}
+	}
+
+	b, err := m.Searcher.Encode()
+	if err != nil {
+		return
+	}
+	buf.Write(b)
+
+	data = buf.Bytes()
+	return
+}
+
+// Decode decodes the message from binary data, the version is set from the message type.
+func (m *GlobalSearchRequestMessage) Decode(data []byte) (err error) {
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *GlobalSearchRequestMessage) decode(data []byte, d *TagDecoder) (err error) {
+	if len(data) < DatagramHeaderLength {
+		return decodeError(MessageGlobalSearchRequest, len(data), "datagram header", ErrShortBuffer)
+	}
+	switch data[1] {
+	case MessageGlobalSearchRequest:
+		m.Version = 1
+	case MessageGlobalSearchRequest2:
+		m.Version = 2
+	case MessageGlobalSearchRequest3:
+		m.Version = 3
+	default:
+		return decodeError(MessageGlobalSearchRequest, 1, fmt.Sprintf("message type %#x", data[1]), ErrWrongMessageType)
+	}
+	header, err := decodeDatagramHeader(data, data[1], 1)
+	if err != nil {
+		return
+	}
+	m.Header = header
+
+	pos := DatagramHeaderLength
+	m.Tags = nil
+	if m.Version == 3 {
+		if len(data) < pos+4 {
+			return decodeError(data[1], pos, "tag count", ErrShortBuffer)
+		}
+		tagCount := binary.LittleEndian.Uint32(data[pos : pos+4])
+		pos += 4
+		if m.Tags, pos, err = readTags(d, data, pos, len(data), tagCount, data[1]); err != nil {
+			return
+		}
+	}
+	if m.Searcher, err = ReadSearcher(bytes.NewReader(data[pos:])); err != nil {
+		err = decodeError(data[1], pos, "search expression", err)
+	}
+	return
+}
+
+// Type is the message type.
+func (m *GlobalSearchRequestMessage) Type() uint8 {
+	return m.opcode()
+}
+
+func (m *GlobalSearchRequestMessage) String() string {
+	b := bytes.Buffer{}
+	fmt.Fprintf(&b, "[global-search-request v%d]\n", m.Version)
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	for i, tag := range m.Tags {
+		fmt.Fprintf(&b, "tag%d - %v: %v\n", i, tag.Name(), tag.Value())
+	}
+	fmt.Fprint(&b, m.Searcher)
+	return b.String()
+}
+
+// GlobalSearchResultMessage message sent from the server to the client as a reply to a global search request.
+// Each result is a separate message, the server packs several results in a datagram one after another.
+type GlobalSearchResultMessage struct {
+	message
+	Files []File
+}
+
+// Encode encodes the message to binary data, each file is written with its own message header.
+func (m *GlobalSearchResultMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	for i := range m.Files {
+		if err = writeDatagramHeader(buf, m.Header, MessageGlobalSearchResult); err != nil {
+			return
+		}
+		if _, err = m.Files[i].WriteTo(buf); err != nil {
+			return
+		}
+	}
+
+	data = buf.Bytes()
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *GlobalSearchResultMessage) Decode(data []byte) (err error) {
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *GlobalSearchResultMessage) decode(data []byte, d *TagDecoder) (err error) {
+	header, err := decodeDatagramHeader(data, MessageGlobalSearchResult, 26)
+	if err != nil {
+		return
+	}
+	m.Header = header
+
+	m.Files = nil
+	pos := DatagramHeaderLength
+	for {
+		var files []File
+		if files, pos, err = readFiles(d, data, pos, len(data), 1, MessageGlobalSearchResult); err != nil {
+			return
+		}
+		m.Files = append(m.Files, files...)
+
+		if pos == len(data) {
+			break
+		}
+		if len(data) < pos+DatagramHeaderLength ||
+			data[pos] != header.Protocol || data[pos+1] != MessageGlobalSearchResult {
+			return decodeError(MessageGlobalSearchResult, pos, "next result header", ErrWrongMessageType)
+		}
+		pos += DatagramHeaderLength
+	}
+	return
+}
+
+// Type is the message type.
+func (m GlobalSearchResultMessage) Type() uint8 {
+	return MessageGlobalSearchResult
+}
+
+func (m GlobalSearchResultMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[global-search-result]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\nfiles:\n")
+	for i, file := range m.Files {
+		fmt.Fprintf(&b, "file%d - %X %s:%d\n", i, file.Hash, ClientID(file.ClientID).String(), file.Port)
+		for j, tag := range file.Tags {
+			fmt.Fprintf(&b, "tag%d - %v: %v\n", j, tag.Name(), tag.Value())
+		}
+	}
+	return b.String()
+}
+
+// GlobalGetSourcesMessage message sent from the client to a server it is not connected to, requesting sources for files.
+type GlobalGetSourcesMessage struct {
+	message
+	Hashes [][16]byte
+}
+
+// Encode encodes the message to binary data.
+func (m *GlobalGetSourcesMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeDatagramHeader(buf, m.Header, MessageGlobalGetSources); err != nil {
+		return
+	}
+	for _, hash := range m.Hashes {
+		buf.Write(hash[:])
+	}
+
+	data = buf.Bytes()
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *GlobalGetSourcesMessage) Decode(data []byte) (err error) {
+	header, err := decodeDatagramHeader(data, MessageGlobalGetSources, 16)
+	if err != nil {
+		return
+	}
+	m.Header = header
+
+	m.Hashes = nil
+	for pos := DatagramHeaderLength; pos+16 <= len(data); pos += 16 {
+		var hash [16]byte
+		copy(hash[:], data[pos:pos+16])
+		m.Hashes = append(m.Hashes, hash)
+	}
+	return
+}
+
+// Type is the message type.
+func (m GlobalGetSourcesMessage) Type() uint8 {
+	return MessageGlobalGetSources
+}
+
+func (m GlobalGetSourcesMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[global-get-sources]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\nhashes:\n")
+	for i, hash := range m.Hashes {
+		fmt.Fprintf(&b, "%d - %X\n", i, hash)
+	}
+	return b.String()
+}
+
+// FileIdent identifies a file by hash and size.
+type FileIdent struct {
+	Hash [16]byte
+	Size uint64
+}
+
+// GlobalGetSources2Message message sent from the client to a server it is not connected to,
+// requesting sources for files. Unlike GlobalGetSourcesMessage, the size of each file is sent.
+type GlobalGetSources2Message struct {
+	message
+	Files []FileIdent
+}
+
+// Encode encodes the message to binary data.
+func (m *GlobalGetSources2Message) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeDatagramHeader(buf, m.Header, MessageGlobalGetSources2); err != nil {
+		return
+	}
+	for _, file := range m.Files {
+		buf.Write(file.Hash[:])
+		if file.Size > math.MaxUint32 {
+			// large file: zero 32-bit size followed by the 64-bit size.
+			binary.Write(buf, binary.LittleEndian, uint32(0))
+			binary.Write(buf, binary.LittleEndian, file.Size)
+		} else {
+			binary.Write(buf, binary.LittleEndian, uint32(file.Size))
+		}
+	}
+
+	data = buf.Bytes()
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *GlobalGetSources2Message) Decode(data []byte) (err error) {
+	header, err := decodeDatagramHeader(data, MessageGlobalGetSources2, 20)
+	if err != nil {
+		return
+	}
+	m.Header = header
+
+	m.Files = nil
+	pos := DatagramHeaderLength
+	for pos < len(data) {
+		if len(data) < pos+20 {
+			return decodeError(MessageGlobalGetSources2, pos, "file", ErrShortBuffer)
+		}
+		file := FileIdent{}
+		copy(file.Hash[:], data[pos:pos+16])
+		pos += 16
+		file.Size = uint64(binary.LittleEndian.Uint32(data[pos : pos+4]))
+		pos += 4
+		if file.Size == 0 {
+			if len(data) < pos+8 {
+				return decodeError(MessageGlobalGetSources2, pos, "large file size", ErrShortBuffer)
+			}
+			file.Size = binary.LittleEndian.Uint64(data[pos : pos+8])
+			pos += 8
+		}
+		m.Files = append(m.Files, file)
+	}
+	return
+}
+
+// Type is the message type.
+func (m GlobalGetSources2Message) Type() uint8 {
+	return MessageGlobalGetSources2
+}
+
+func (m GlobalGetSources2Message) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[global-get-sources2]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\nfiles:\n")
+	for i, file := range m.Files {
+		fmt.Fprintf(&b, "%d - %X, size: %d\n", i, file.Hash, file.Size)
+	}
+	return b.String()
+}
+
+// FileSources is the list of sources of a file.
+type FileSources struct {
+	Hash    [16]byte
+	Sources []*net.TCPAddr
+}
+
+// GlobalFoundSourcesMessage message sent from the server to the client as a reply to a global get sources request.
+// The sources of each file are a separate message, the server packs several of them in a datagram one after another.
+type GlobalFoundSourcesMessage struct {
+	message
+	Files []FileSources
+}
+
+// Encode encodes the message to binary data, the sources of each file are written with its own message header.
+func (m *GlobalFoundSourcesMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	for _, file := range m.Files {
+		if len(file.Sources) > math.MaxUint8 {
+			err = fmt.Errorf("too many sources: %d", len(file.Sources))
+			return
+		}
+		if err = writeDatagramHeader(buf, m.Header, MessageGlobalFoundSources); err != nil {
+			return
+		}
+		buf.Write(file.Hash[:])
+		buf.WriteByte(uint8(len(file.Sources)))
+		for _, source := range file.Sources {
+			if source == nil {
+				source = &net.TCPAddr{
+					IP:   net.IPv4zero,
+					Port: 0,
+				}
+			}
+			buf.Write(source.IP.To4())
+			binary.Write(buf, binary.LittleEndian, uint16(source.Port))
+		}
+	}
+
+	data = buf.Bytes()
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *GlobalFoundSourcesMessage) Decode(data []byte) (err error) {
+	header, err := decodeDatagramHeader(data, MessageGlobalFoundSources, 17)
+	if err != nil {
+		return
+	}
+	m.Header = header
+
+	m.Files = nil
+	pos := DatagramHeaderLength
+	for {
+		if len(data) < pos+17 {
+			return decodeError(MessageGlobalFoundSources, pos, "file", ErrShortBuffer)
+		}
+		file := FileSources{}
+		copy(file.Hash[:], data[pos:pos+16])
+		pos += 16
+		count := int(data[pos])
+		pos++
+		if len(data) < pos+count*6 {
+			return decodeError(MessageGlobalFoundSources, pos, "source count", ErrShortBuffer)
+		}
+		for i := 0; i < count; i++ {
+			file.Sources = append(file.Sources,
+				&net.TCPAddr{
+					IP:   net.IP(data[pos : pos+4]),
+					Port: int(binary.LittleEndian.Uint16(data[pos+4 : pos+6])),
+				})
+			pos += 6
+		}
+		m.Files = append(m.Files, file)
+
+		if pos == len(data) {
+			break
+		}
+		if len(data) < pos+DatagramHeaderLength ||
+			data[pos] != header.Protocol || data[pos+1] != MessageGlobalFoundSources {
+			return decodeError(MessageGlobalFoundSources, pos, "next result header", ErrWrongMessageType)
+		}
+		pos += DatagramHeaderLength
+	}
+	return
+}
+
+// Type is the message type.
+func (m GlobalFoundSourcesMessage) Type() uint8 {
+	return MessageGlobalFoundSources
+}
+
+func (m GlobalFoundSourcesMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[global-found-sources]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\nfiles:\n")
+	for i, file := range m.Files {
+		var ss []string
+		for _, src := range file.Sources {
+			ss = append(ss, src.String())
+		}
+		fmt.Fprintf(&b, "%d - %X: %s\n", i, file.Hash, strings.Join(ss, ","))
+	}
+	return b.String()
+}
+
+// ServerDescChallenge returns the server description request challenge made from the random value r.
+func ServerDescChallenge(r uint16) uint32 {
+	return uint32(r)<<16 | ServerDescChallengeMask
+}
+
+// ServerDescRequestMessage message sent from the client to a server requesting the server name and description.
+// With a challenge, built by ServerDescChallenge, the server answers with the new tagged form.
+// The old form without challenge is sent if Challenge is 0.
+type ServerDescRequestMessage struct {
+	message
+	Challenge uint32
+}
+
+// Encode encodes the message to binary data.
+func (m *ServerDescRequestMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeDatagramHeader(buf, m.Header, MessageServerDescRequest); err != nil {
+		return
+	}
+	if m.Challenge != 0 {
+		binary.Write(buf, binary.LittleEndian, m.Challenge)
+	}
+
+	data = buf.Bytes()
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *ServerDescRequestMessage) Decode(data []byte) (err error) {
+	header, err := decodeDatagramHeader(data, MessageServerDescRequest, 0)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	m.Challenge = 0
+	if len(data) >= DatagramHeaderLength+4 {
+		m.Challenge = binary.LittleEndian.Uint32(data[DatagramHeaderLength:])
+	}
+	return
+}
+
+// Type is the message type.
+func (m ServerDescRequestMessage) Type() uint8 {
+	return MessageServerDescRequest
+}
+
+func (m ServerDescRequestMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[server-desc-request]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "challenge: %#08x", m.Challenge)
+	return b.String()
+}
+
+// ServerDescResponseMessage message sent from the server to the client as a reply to a server description request.
+// In the new form, used if Challenge is not 0, the server name and description are sent as tags,
+// on decoding Name and Desc are also set from the tags. The old form has only the name and description.
+type ServerDescResponseMessage struct {
+	message
+	Challenge uint32
+	Tags      Tags
+	Name      string
+	Desc      string
+}
+
+// Encode encodes the message to binary data.
+func (m *ServerDescResponseMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if err = writeDatagramHeader(buf, m.Header, MessageServerDescResponse); err != nil {
+		return
+	}
+	if m.Challenge != 0 {
+		binary.Write(buf, binary.LittleEndian, m.Challenge)
+		binary.Write(buf, binary.LittleEndian, uint32(len(m.Tags)))
+		for _, tag := range m.Tags {
+			if _, err = tag.WriteTo(buf); err != nil {
+				return
+			}
+		}
+	} else {
+		binary.Write(buf, binary.LittleEndian, uint16(len(m.Name)))
+		buf.WriteString(m.Name)
+		binary.Write(buf, binary.LittleEndian, uint16(len(m.Desc)))
+		buf.WriteString(m.Desc)
+	}
+
+	data = buf.Bytes()
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *ServerDescResponseMessage) Decode(data []byte) (err error) {
+	return m.decode(data, DefaultTagDecoder)
+}
+
+func (m *ServerDescResponseMessage) decode(data []byte, d *TagDecoder) (err error) {
+	header, err := decodeDatagramHeader(data, MessageServerDescResponse, 2)
+	if err != nil {
+		return
+	}
+	m.Header = header
+
+	pos := DatagramHeaderLength
+	if binary.LittleEndian.Uint16(data[pos:pos+2]) != ServerDescChallengeMask {
+		r := bytes.NewReader(data[pos:])
+		if m.Name, err = readSearchString(r); err != nil {
+			return decodeError(MessageServerDescResponse, pos, "name", err)
+		}
+		if m.Desc, err = readSearchString(r); err != nil {
+			return decodeError(MessageServerDescResponse, len(data)-r.Len(), "description", err)
+		}
+		return
+	}
+
+	if len(data) < pos+8 {
+		return decodeError(MessageServerDescResponse, pos, "challenge", ErrShortBuffer)
+	}
+	m.Challenge = binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
+	tagCount := binary.LittleEndian.Uint32(data[pos : pos+4])
+	pos += 4
+	if m.Tags, _, err = readTags(d, data, pos, len(data), tagCount, MessageServerDescResponse); err != nil {
+		return
+	}
+	m.Name, _ = m.Tags.String(TagName)
+	m.Desc, _ = m.Tags.String(TagDesc)
+	return
+}
+
+// Type is the message type.
+func (m ServerDescResponseMessage) Type() uint8 {
+	return MessageServerDescResponse
+}
+
+func (m ServerDescResponseMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[server-desc-response]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "challenge: %#08x, name: %s, desc: %s\n", m.Challenge, m.Name, m.Desc)
+	for i, tag := range m.Tags {
+		fmt.Fprintf(&b, "tag%d - %v: %v\n", i, tag.Name(), tag.Value())
+	}
+	return b.String()
+}
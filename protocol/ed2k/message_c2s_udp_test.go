@@ -0,0 +1,124 @@
+package ed2k
+
+import (
+	"bytes"
+	"net"
+	"testing"
+)
+
+func TestCSUDPMessage(t *testing.T) {
+	hash := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	file := []byte{
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, // hash
+		1, 2, 3, 4, // client ID
+		0x36, 0x12, // port
+		1, 0, 0, 0, // tag count
+		TagUint32 | 0x80, TagSize, 0, 0, 0xC0, 0x2B, // size
+	}
+
+	testCases := [][]byte{
+		{ProtoEDonkey, MessageGlobalServerStatusRequest, 0x01, 0x02, 0xAA, 0x55},
+		{
+			ProtoEDonkey, MessageGlobalServerStatusResponse,
+			0x01, 0x02, 0xAA, 0x55, 100, 0, 0, 0, 200, 0, 0, 0,
+		},
+		{
+			ProtoEDonkey, MessageGlobalServerStatusResponse,
+			0x01, 0x02, 0xAA, 0x55, 100, 0, 0, 0, 200, 0, 0, 0, // challenge, users, files
+			0xE8, 0x03, 0, 0, // max users
+			0xE8, 0x03, 0, 0, 0xD0, 0x07, 0, 0, // soft and hard files
+			0x3B, 0x07, 0, 0, // udp flags
+			10, 0, 0, 0, // lowid users
+			0x39, 0x12, 0x40, 0x12, 1, 2, 3, 4, // obfuscation ports and udp key
+		},
+		{ProtoEDonkey, MessageGlobalSearchRequest, SearchName, 1, 0, 'a'},
+		{ProtoEDonkey, MessageGlobalSearchRequest2, SearchName, 1, 0, 'a'},
+		{
+			ProtoEDonkey, MessageGlobalSearchRequest3,
+			1, 0, 0, 0, TagUint32 | 0x80, byte(CTServerUDPSearchFlags), 0x00, 0x01, 0, 0,
+			SearchName, 1, 0, 'a',
+		},
+		append([]byte{ProtoEDonkey, MessageGlobalSearchResult}, file...),
+		append(append(append([]byte{ProtoEDonkey, MessageGlobalSearchResult}, file...),
+			ProtoEDonkey, MessageGlobalSearchResult), file...),
+		append(append([]byte{ProtoEDonkey, MessageGlobalGetSources}, hash[:]...), hash[:]...),
+		append(append(append([]byte{ProtoEDonkey, MessageGlobalGetSources2}, hash[:]...),
+			0, 0, 0xC0, 0x2B), append(hash[:], 0, 0, 0, 0, 0, 0, 0, 0x40, 1, 0, 0, 0)...),
+		append(append(append([]byte{ProtoEDonkey, MessageGlobalFoundSources}, hash[:]...),
+			2, 192, 168, 1, 1, 0x36, 0x12, 10, 0, 0, 1, 0x37, 0x12,
+			ProtoEDonkey, MessageGlobalFoundSources), append(hash[:], 0)...),
+		{ProtoEDonkey, MessageServerDescRequest},
+		{ProtoEDonkey, MessageServerDescRequest, 0xFF, 0xF0, 0x34, 0x12},
+		{ProtoEDonkey, MessageServerDescResponse, 1, 0, 'a', 2, 0, 'b', 'c'},
+		{
+			ProtoEDonkey, MessageServerDescResponse,
+			0xFF, 0xF0, 0x34, 0x12, 2, 0, 0, 0,
+			TagStr1 | 0x80, TagName, 'a',
+			TagStr2 | 0x80, TagDesc, 'b', 'c',
+		},
+		{ProtoEDonkey, 0xEE, 1, 2, 3},
+	}
+
+	for i, tc := range testCases {
+		m, err := ReadMessage(bytes.NewReader(tc), CSUDPMessage)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if m.Type() != tc[1] {
+			t.Errorf("%d: type %#x, want %#x", i, m.Type(), tc[1])
+		}
+		b, err := m.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(b, tc) {
+			t.Errorf("%d: %s\n%# x\n%# x", i, m, b, tc)
+		}
+	}
+}
+
+func TestCSUDPMessageDecode(t *testing.T) {
+	m, err := DecodeDatagram([]byte{
+		ProtoEDonkey, MessageServerDescResponse,
+		0xFF, 0xF0, 0x34, 0x12, 2, 0, 0, 0,
+		TagStr1 | 0x80, TagName, 'a',
+		TagStr2 | 0x80, TagDesc, 'b', 'c',
+	}, CSUDPMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := m.(*ServerDescResponseMessage)
+	if desc.Challenge != ServerDescChallenge(0x1234) || desc.Name != "a" || desc.Desc != "bc" {
+		t.Errorf("unexpected message %v", desc)
+	}
+
+	m, err = DecodeDatagram([]byte{
+		ProtoEDonkey, MessageGlobalFoundSources,
+		1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16,
+		1, 192, 168, 1, 1, 0x36, 0x12,
+	}, CSUDPMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := m.(*GlobalFoundSourcesMessage)
+	if len(found.Files) != 1 || len(found.Files[0].Sources) != 1 ||
+		!found.Files[0].Sources[0].IP.Equal(net.IPv4(192, 168, 1, 1)) || found.Files[0].Sources[0].Port != 4662 {
+		t.Errorf("unexpected message %v", found)
+	}
+
+	invalid := [][]byte{
+		{},
+		{ProtoEDonkey},
+		{0x01, MessageGlobalServerStatusRequest, 0, 0, 0, 0},
+		{ProtoEDonkey, MessageGlobalServerStatusRequest, 0, 0},
+		{ProtoEDonkey, MessageGlobalServerStatusResponse, 0, 0, 0, 0, 0, 0, 0, 0},
+		{ProtoEDonkey, MessageGlobalGetSources2, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 0, 0, 0, 0},
+		{ProtoEDonkey, MessageGlobalFoundSources, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 1},
+		{ProtoEDonkey, MessageServerDescResponse, 0xFF, 0xF0, 0, 0},
+	}
+	for i, tc := range invalid {
+		if _, err := DecodeDatagram(tc, CSUDPMessage); err == nil {
+			t.Errorf("%d: invalid datagram decoded", i)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package ed2k
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+const (
+	// MaxUnpackedSize is the maximum size in bytes of the inflated payload of a packed message.
+	// It guards against zip bombs sent by malicious peers.
+	MaxUnpackedSize = 2 << 20
+)
+
+// errors
+var (
+	ErrUnpackedTooLarge = errors.New("unpacked message too large")
+)
+
+// Pack compresses the payload of the encoded message data with zlib and
+// returns the packed message with protocol ProtoPacked.
+// The message type is kept uncompressed. As eMule does, if compression does not
+// reduce the message size, data is returned unchanged.
+func Pack(data []byte) (packed []byte, err error) {
+	if len(data) < HeaderLength+1 {
+		err = ErrShortBuffer
+		return
+	}
+	payload := data[HeaderLength+1:]
+
+	buf := new(bytes.Buffer)
+	buf.Write(data[:HeaderLength+1])
+	zw, err := zlib.NewWriterLevel(buf, zlib.BestCompression)
+	if err != nil {
+		return
+	}
+	if _, err = zw.Write(payload); err != nil {
+		return
+	}
+	if err = zw.Close(); err != nil {
+		return
+	}
+	if buf.Len() >= len(data) {
+		packed = data
+		return
+	}
+
+	packed = buf.Bytes()
+	packed[0] = ProtoPacked
+	size := len(packed) - HeaderLength
+	binary.LittleEndian.PutUint32(packed[1:5], uint32(size)) // message size
+	return
+}
+
+// PackMessage encodes the message m sent on a connection of class, the message is packed only if flags
+// has CapZlib set, which means the peer advertised support for compression.
+// As eMule does, only the messages of the protocol restored by the reader are packed, eDonkey messages
+// to a server and eMule messages to a client, other messages are sent unpacked.
+func PackMessage(m Message, class int, flags uint32) (data []byte, err error) {
+	if data, err = m.Encode(); err != nil {
+		return
+	}
+	if flags&CapZlib == 0 {
+		return
+	}
+	if proto, ok := unpackedProto(class); !ok || len(data) == 0 || data[0] != proto {
+		return
+	}
+	return Pack(data)
+}
+
+// unpackedProto returns the protocol of the packed messages of class once unpacked,
+// server messages are eDonkey messages and client messages are eMule messages.
+// ok is false if the messages of class are not packed.
+func unpackedProto(class int) (proto uint8, ok bool) {
+	switch class {
+	case CSTCPMessage:
+		return ProtoEDonkey, true
+	case CCTCPMessage:
+		return ProtoEMule, true
+	}
+	return 0, false
+}
+
+// unpack inflates the payload of the packed message data.
+// The header of the returned message is rewritten with protocol proto and the inflated size.
+// The inflated payload must not be larger than limit.
+func unpack(data []byte, proto uint8, limit int) (unpacked []byte, err error) {
+	if len(data) < HeaderLength+1 {
+		err = ErrShortBuffer
+		return
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(data[HeaderLength+1:]))
+	if err != nil {
+		return
+	}
+	defer zr.Close()
+
+	buf := new(bytes.Buffer)
+	buf.Write(data[:HeaderLength+1])
+	n, err := io.Copy(buf, io.LimitReader(zr, int64(limit)+1))
+	if err != nil {
+		return
+	}
+	if n > int64(limit) {
+		err = ErrUnpackedTooLarge
+		return
+	}
+
+	unpacked = buf.Bytes()
+	unpacked[0] = proto
+	size := len(unpacked) - HeaderLength
+	binary.LittleEndian.PutUint32(unpacked[1:5], uint32(size)) // message size
+	return
+}
@@ -0,0 +1,89 @@
+package ed2k
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+func TestPackMessage(t *testing.T) {
+	m := &SearchResultMessage{}
+	for i := 0; i < 10; i++ {
+		m.Files = append(m.Files, File{
+			Hash: [16]byte{byte(i)},
+			Tags: []Tag{
+				StringTag(TagName, "ubuntu-16.04-desktop-amd64.iso", false),
+				Uint32Tag(TagSize, 1485881344),
+			},
+		})
+	}
+	raw, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := PackMessage(m, CSTCPMessage, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, raw) {
+		t.Error("message packed without CapZlib")
+	}
+
+	b, err = PackMessage(m, CSTCPMessage, CapZlib)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b[0] != ProtoPacked || b[5] != MessageSearchResult || len(b) >= len(raw) {
+		t.Fatalf("message not packed: %# x", b[:6])
+	}
+	if size := binary.LittleEndian.Uint32(b[1:5]); int(size) != len(b)-HeaderLength {
+		t.Errorf("wrong packed size %d", size)
+	}
+
+	msg, err := ReadMessage(bytes.NewReader(b), CSTCPMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, ok := msg.(*SearchResultMessage)
+	if !ok {
+		t.Fatalf("wrong message %T", msg)
+	}
+	if result.Protocol() != ProtoEDonkey || len(result.Files) != len(m.Files) {
+		t.Fatal(result)
+	}
+	for i := range result.Files {
+		if result.Files[i].Hash != m.Files[i].Hash || result.Files[i].Tags[0].Value() != "ubuntu-16.04-desktop-amd64.iso" {
+			t.Errorf("file%d mismatch", i)
+		}
+	}
+}
+
+func TestPackIncompressible(t *testing.T) {
+	data := []byte{ProtoEDonkey, 2, 0, 0, 0, MessageServerMessage, 0}
+	b, err := Pack(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, data) {
+		t.Errorf("%# x", b)
+	}
+}
+
+func TestReadPackedMessageTooLarge(t *testing.T) {
+	buf := new(bytes.Buffer)
+	zw := zlib.NewWriter(buf)
+	zw.Write(make([]byte, MaxUnpackedSize+1))
+	zw.Close()
+
+	data := []byte{ProtoPacked, 0, 0, 0, 0, MessageSearchResult}
+	data = append(data, buf.Bytes()...)
+	binary.LittleEndian.PutUint32(data[1:5], uint32(len(data)-HeaderLength))
+
+	var de *DecodeError
+	if _, err := ReadMessage(bytes.NewReader(data), CSTCPMessage); !errors.As(err, &de) || !errors.Is(err, ErrUnpackedTooLarge) {
+		t.Errorf("got %v, want %v", err, ErrUnpackedTooLarge)
+	}
+}
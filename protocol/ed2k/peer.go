@@ -0,0 +1,163 @@
+package ed2k
+
+// PeerCapabilities are the client settings and features announced in the hello and hello answer tags.
+// The eMule features are packed in the misc options tags as version numbers and flags.
+type PeerCapabilities struct {
+	// The user nick name.
+	Name string
+	// The eDonkey version, EDonkeyVerion if 0.
+	Version uint32
+	// The UDP ports of the client, for eMule and Kad.
+	UDPPort uint16
+	KadPort uint16
+	// The mod name, it is not sent if empty.
+	ModVersion string
+	// The eMule version, EMuleVersion if 0.
+	EMuleVersion uint32
+
+	// misc options 1.
+	AICHVersion            uint8 // 3 bits
+	Unicode                bool
+	UDPVersion             uint8 // 4 bits
+	DataCompressionVersion uint8 // 4 bits
+	SecureIdentVersion     uint8 // 4 bits
+	SourceExchangeVersion  uint8 // 4 bits
+	ExtRequestsVersion     uint8 // 4 bits
+	CommentsVersion        uint8 // 4 bits
+	PeerCache              bool
+	NoViewSharedFiles      bool
+	MultiPacket            bool
+	Preview                bool
+
+	// misc options 2.
+	KadVersion        uint8 // 4 bits
+	LargeFiles        bool
+	ExtMultiPacket    bool
+	SupportCrypt      bool
+	RequestCrypt      bool
+	RequireCrypt      bool
+	SourceExchange2   bool
+	Captcha           bool
+	DirectUDPCallback bool
+	FileIdentifiers   bool
+}
+
+// MiscOptions1 returns the CT_EMULE_MISCOPTIONS1 tag value of the capabilities.
+func (c *PeerCapabilities) MiscOptions1() uint32 {
+	return uint32(c.AICHVersion&0x07)<<29 |
+		bit(c.Unicode)<<28 |
+		uint32(c.UDPVersion&0x0F)<<24 |
+		uint32(c.DataCompressionVersion&0x0F)<<20 |
+		uint32(c.SecureIdentVersion&0x0F)<<16 |
+		uint32(c.SourceExchangeVersion&0x0F)<<12 |
+		uint32(c.ExtRequestsVersion&0x0F)<<8 |
+		uint32(c.CommentsVersion&0x0F)<<4 |
+		bit(c.PeerCache)<<3 |
+		bit(c.NoViewSharedFiles)<<2 |
+		bit(c.MultiPacket)<<1 |
+		bit(c.Preview)
+}
+
+// SetMiscOptions1 sets the capabilities of the CT_EMULE_MISCOPTIONS1 tag value v.
+func (c *PeerCapabilities) SetMiscOptions1(v uint32) {
+	c.AICHVersion = uint8(v >> 29 & 0x07)
+	c.Unicode = v>>28&0x01 != 0
+	c.UDPVersion = uint8(v >> 24 & 0x0F)
+	c.DataCompressionVersion = uint8(v >> 20 & 0x0F)
+	c.SecureIdentVersion = uint8(v >> 16 & 0x0F)
+	c.SourceExchangeVersion = uint8(v >> 12 & 0x0F)
+	c.ExtRequestsVersion = uint8(v >> 8 & 0x0F)
+	c.CommentsVersion = uint8(v >> 4 & 0x0F)
+	c.PeerCache = v>>3&0x01 != 0
+	c.NoViewSharedFiles = v>>2&0x01 != 0
+	c.MultiPacket = v>>1&0x01 != 0
+	c.Preview = v&0x01 != 0
+}
+
+// MiscOptions2 returns the CT_EMULE_MISCOPTIONS2 tag value of the capabilities.
+func (c *PeerCapabilities) MiscOptions2() uint32 {
+	return bit(c.FileIdentifiers)<<13 |
+		bit(c.DirectUDPCallback)<<12 |
+		bit(c.Captcha)<<11 |
+		bit(c.SourceExchange2)<<10 |
+		bit(c.RequireCrypt)<<9 |
+		bit(c.RequestCrypt)<<8 |
+		bit(c.SupportCrypt)<<7 |
+		bit(c.ExtMultiPacket)<<5 |
+		bit(c.LargeFiles)<<4 |
+		uint32(c.KadVersion&0x0F)
+}
+
+// SetMiscOptions2 sets the capabilities of the CT_EMULE_MISCOPTIONS2 tag value v.
+func (c *PeerCapabilities) SetMiscOptions2(v uint32) {
+	c.FileIdentifiers = v>>13&0x01 != 0
+	c.DirectUDPCallback = v>>12&0x01 != 0
+	c.Captcha = v>>11&0x01 != 0
+	c.SourceExchange2 = v>>10&0x01 != 0
+	c.RequireCrypt = v>>9&0x01 != 0
+	c.RequestCrypt = v>>8&0x01 != 0
+	c.SupportCrypt = v>>7&0x01 != 0
+	c.ExtMultiPacket = v>>5&0x01 != 0
+	c.LargeFiles = v>>4&0x01 != 0
+	c.KadVersion = uint8(v & 0x0F)
+}
+
+func bit(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// Tags returns the hello tags of the capabilities.
+func (c *PeerCapabilities) Tags() Tags {
+	version := c.Version
+	if version == 0 {
+		version = EDonkeyVerion
+	}
+	emuleVersion := c.EMuleVersion
+	if emuleVersion == 0 {
+		emuleVersion = EMuleVersion
+	}
+	tags := Tags{
+		StringTag(CTName, c.Name, false),
+		Uint32Tag(CTVersion, version),
+		Uint32Tag(CTEMuleUDPPorts, uint32(c.KadPort)<<16|uint32(c.UDPPort)),
+		Uint32Tag(CTEMuleMiscOptions1, c.MiscOptions1()),
+		Uint32Tag(CTEMuleMiscOptions2, c.MiscOptions2()),
+	}
+	if c.ModVersion != "" {
+		tags = append(tags, StringTag(CTModVersion, c.ModVersion, false))
+	}
+	tags = append(tags, Uint32Tag(CTEMuleVersion, emuleVersion))
+	return tags
+}
+
+// ParsePeerCapabilities reads the capabilities from the hello tags, the missing tags are left zero.
+func ParsePeerCapabilities(tags Tags) PeerCapabilities {
+	c := PeerCapabilities{}
+	c.Name, _ = tags.String(CTName)
+	c.Version, _ = tags.Uint32(CTVersion)
+	if ports, ok := tags.Uint32(CTEMuleUDPPorts); ok {
+		c.KadPort, c.UDPPort = uint16(ports>>16), uint16(ports)
+	}
+	c.ModVersion, _ = tags.String(CTModVersion)
+	c.EMuleVersion, _ = tags.Uint32(CTEMuleVersion)
+	if v, ok := tags.Uint32(CTEMuleMiscOptions1); ok {
+		c.SetMiscOptions1(v)
+	}
+	if v, ok := tags.Uint32(CTEMuleMiscOptions2); ok {
+		c.SetMiscOptions2(v)
+	}
+	return c
+}
+
+// Capabilities returns the capabilities announced in the hello tags.
+func (m *HelloMessage) Capabilities() PeerCapabilities {
+	return ParsePeerCapabilities(m.Tags)
+}
+
+// Capabilities returns the capabilities announced in the hello answer tags.
+func (m *HelloAnswerMessage) Capabilities() PeerCapabilities {
+	return ParsePeerCapabilities(m.Tags)
+}
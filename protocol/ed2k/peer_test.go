@@ -0,0 +1,65 @@
+package ed2k
+
+import (
+	"testing"
+)
+
+func TestPeerCapabilities(t *testing.T) {
+	c := PeerCapabilities{
+		Name:                   "gmule",
+		Version:                EDonkeyVerion,
+		UDPPort:                4672,
+		KadPort:                4673,
+		ModVersion:             "mod",
+		EMuleVersion:           EMuleVersion,
+		AICHVersion:            1,
+		Unicode:                true,
+		UDPVersion:             4,
+		DataCompressionVersion: 1,
+		SecureIdentVersion:     2,
+		SourceExchangeVersion:  3,
+		ExtRequestsVersion:     2,
+		CommentsVersion:        1,
+		MultiPacket:            true,
+		KadVersion:             8,
+		LargeFiles:             true,
+		ExtMultiPacket:         true,
+		SupportCrypt:           true,
+		RequestCrypt:           true,
+		SourceExchange2:        true,
+		DirectUDPCallback:      true,
+		FileIdentifiers:        true,
+	}
+	if v := c.MiscOptions1(); v != 0x34123212 {
+		t.Errorf("misc options 1 %#x", v)
+	}
+	if v := c.MiscOptions2(); v != 0x35B8 {
+		t.Errorf("misc options 2 %#x", v)
+	}
+
+	hello := &HelloMessage{UID: NewUID(), Port: 4662, Tags: c.Tags()}
+	data, err := hello.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &HelloMessage{}
+	if err := m.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Capabilities(); got != c {
+		t.Errorf("got %+v, want %+v", got, c)
+	}
+	if ports, _ := m.Tags.Uint32(CTEMuleUDPPorts); ports != 4673<<16|4672 {
+		t.Errorf("udp ports %#x", ports)
+	}
+
+	var all PeerCapabilities
+	all.SetMiscOptions1(0xFFFFFFFF)
+	all.SetMiscOptions2(0xFFFFFFFF)
+	if all.MiscOptions1() != 0xFFFFFFFF || all.MiscOptions2() != 0x3FBF {
+		t.Errorf("misc options %#x %#x", all.MiscOptions1(), all.MiscOptions2())
+	}
+	if c := ParsePeerCapabilities(nil); c != (PeerCapabilities{}) {
+		t.Errorf("capabilities without tags %+v", c)
+	}
+}
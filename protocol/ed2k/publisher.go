@@ -0,0 +1,235 @@
+package ed2k
+
+import (
+	"math"
+	"sync"
+)
+
+const (
+	// DefaultMaxOfferFiles is the default maximum number of files in an offer files message.
+	DefaultMaxOfferFiles = 200
+)
+
+// Special client ID and port of the offered files, used on servers supporting compression
+// instead of the client address, to tell complete from partial files.
+const (
+	OfferCompleteID   = 0xFBFBFBFB
+	OfferCompletePort = 0xFBFB
+	OfferPartialID    = 0xFCFCFCFC
+	OfferPartialPort  = 0xFCFC
+)
+
+// SharedFile is a file shared by the client.
+type SharedFile struct {
+	Hash [16]byte
+	Name string
+	Size uint64
+	// The media type, File* constants, optional.
+	Type string
+	// Complete is false for a partial file, still being downloaded.
+	Complete bool
+}
+
+// Publisher publishes the shared files to a server with offer files messages.
+//
+// The shared set is diffed against the files already offered on the current connection, Publish only
+// sends the new or changed files, in batches of at most MaxFiles files. The servers can't unpublish
+// a file, an unshared file is gone from the server only after a reconnect.
+// Passing the session events to HandleEvent re-publishes all the shared files on reconnect.
+//
+// The messages are packed by the connection if the server supports compression (CapZlib),
+// e.g. a ServerSession sets the flags of the connection from the server capabilities.
+type Publisher struct {
+	// Server sends the offer files messages, e.g. a ServerSession.
+	Server MessageWriter
+	// Port is the TCP port of the client, offered with the files when the client has a high ID.
+	Port uint16
+	// MaxFiles is the maximum number of files in a message, DefaultMaxOfferFiles if 0.
+	// The server can set a lower limit.
+	MaxFiles int
+
+	mu        sync.Mutex
+	shared    map[[16]byte]SharedFile
+	order     [][16]byte // shared hashes, in share order
+	published map[[16]byte]SharedFile
+	connected bool
+	conns     int // number of connections, to tell the batches sent on a previous connection
+	clientID  ClientID
+	caps      ServerCapabilities
+}
+
+// NewPublisher creates a publisher sending the offers to w, port is the TCP port of the client.
+func NewPublisher(w MessageWriter, port uint16) *Publisher {
+	return &Publisher{
+		Server:    w,
+		Port:      port,
+		MaxFiles:  DefaultMaxOfferFiles,
+		shared:    make(map[[16]byte]SharedFile),
+		published: make(map[[16]byte]SharedFile),
+	}
+}
+
+// Share adds the files to the shared set, or updates them if already shared, e.g. a partial file
+// which is completed. The files are sent by the next Publish.
+func (p *Publisher) Share(files ...SharedFile) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, f := range files {
+		if _, ok := p.shared[f.Hash]; !ok {
+			p.order = append(p.order, f.Hash)
+		}
+		p.shared[f.Hash] = f
+	}
+}
+
+// Unshare removes the files with the hashes from the shared set.
+func (p *Publisher) Unshare(hashes ...[16]byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, hash := range hashes {
+		if _, ok := p.shared[hash]; !ok {
+			continue
+		}
+		delete(p.shared, hash)
+		delete(p.published, hash)
+		for i, h := range p.order {
+			if h == hash {
+				p.order = append(p.order[:i], p.order[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// Connected resets the published files for a new connection, where the server assigned the client ID id
+// and advertised the capabilities caps. It is called by HandleEvent.
+func (p *Publisher) Connected(id ClientID, caps ServerCapabilities) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connected, p.clientID, p.caps = true, id, caps
+	p.conns++
+	p.published = make(map[[16]byte]SharedFile)
+}
+
+// Disconnected marks the server connection as lost, nothing is published until Connected.
+func (p *Publisher) Disconnected() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.connected = false
+}
+
+// HandleEvent handles the server session event e, it re-publishes all the shared files on connection.
+func (p *Publisher) HandleEvent(e ServerEvent) error {
+	switch e.Kind {
+	case EventConnected:
+		m, ok := e.Message.(*IDChangeMessage)
+		if !ok {
+			return nil
+		}
+		p.Connected(m.ClientID, m.Capabilities())
+		return p.Publish()
+	case EventDisconnected:
+		p.Disconnected()
+	}
+	return nil
+}
+
+// Pending returns the number of shared files not published yet.
+func (p *Publisher) Pending() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.pending())
+}
+
+func (p *Publisher) pending() (files []SharedFile) {
+	for _, hash := range p.order {
+		f := p.shared[hash]
+		if published, ok := p.published[hash]; ok && published == f {
+			continue
+		}
+		files = append(files, f)
+	}
+	return
+}
+
+// Publish sends the new and changed shared files to the server.
+// It returns ErrNotConnected if the publisher is not connected, the files are then sent on connection.
+// If a message fails, the files of the following batches are left pending.
+// The large files (> 4GB) are left pending if the server does not support them.
+func (p *Publisher) Publish() error {
+	for {
+		p.mu.Lock()
+		if !p.connected {
+			p.mu.Unlock()
+			return ErrNotConnected
+		}
+		conns := p.conns
+		files, m, err := p.batch()
+		p.mu.Unlock()
+		if err != nil || len(files) == 0 {
+			return err
+		}
+
+		if err = p.Server.WriteMessage(m); err != nil {
+			return err
+		}
+
+		p.mu.Lock()
+		// the files are sent again on a new connection.
+		if p.conns == conns {
+			for _, f := range files {
+				if _, ok := p.shared[f.Hash]; ok {
+					p.published[f.Hash] = f
+				}
+			}
+		}
+		p.mu.Unlock()
+	}
+}
+
+// batch returns the next pending files to publish, at most MaxFiles, and their offer files message.
+func (p *Publisher) batch() (files []SharedFile, m *OfferFilesMessage, err error) {
+	max := p.MaxFiles
+	if max <= 0 {
+		max = DefaultMaxOfferFiles
+	}
+	m = &OfferFilesMessage{}
+	for _, f := range p.pending() {
+		if len(files) == max {
+			break
+		}
+		if f.Size > math.MaxUint32 && !p.caps.LargeFiles() {
+			continue
+		}
+		var file File
+		if file, err = p.offer(f); err != nil {
+			return
+		}
+		files = append(files, f)
+		m.Files = append(m.Files, file)
+	}
+	return
+}
+
+// offer returns the offered file entry of f, in the form supported by the server.
+func (p *Publisher) offer(f SharedFile) (file File, err error) {
+	file.Hash = f.Hash
+	switch {
+	case p.caps.Compression() && f.Complete:
+		file.ClientID, file.Port = OfferCompleteID, OfferCompletePort
+	case p.caps.Compression():
+		file.ClientID, file.Port = OfferPartialID, OfferPartialPort
+	case !p.clientID.LowID():
+		file.ClientID, file.Port = uint32(p.clientID), p.Port
+	}
+
+	compact := p.caps.NewTags()
+	file.Tags = Tags{StringTag(FTFileName, f.Name, compact)}
+	if err = file.SetSize(f.Size, p.caps.Flags()); err != nil {
+		return
+	}
+	if f.Type != "" {
+		file.Tags = append(file.Tags, StringTag(FTFileType, f.Type, compact))
+	}
+	return
+}
@@ -0,0 +1,170 @@
+package ed2k
+
+import (
+	"net"
+	"testing"
+)
+
+func sharedFiles(complete bool, ids ...byte) []SharedFile {
+	var files []SharedFile
+	for _, id := range ids {
+		files = append(files, SharedFile{Hash: [16]byte{id}, Name: "file", Size: 1 << 33, Complete: complete})
+	}
+	return files
+}
+
+func TestPublisher(t *testing.T) {
+	w := &messageRecorder{}
+	p := NewPublisher(w, 4662)
+	p.MaxFiles = 2
+	p.Share(sharedFiles(true, 1, 2, 3)...)
+	p.Share(sharedFiles(false, 4)...)
+
+	if err := p.Publish(); err != ErrNotConnected {
+		t.Errorf("publish before connection: %v", err)
+	}
+
+	connect := func(id ClientID, caps ServerCapabilities) {
+		err := p.HandleEvent(ServerEvent{Kind: EventConnected, Message: &IDChangeMessage{ClientID: id, Bitmap: uint32(caps)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	connect(0x02000001, ServerFlagCompression|ServerFlagLargeFiles)
+
+	if len(w.messages) != 2 {
+		t.Fatalf("got %d messages, want 2", len(w.messages))
+	}
+	var offered []File
+	for i, m := range w.messages {
+		files := m.(*OfferFilesMessage).Files
+		if len(files) > 2 {
+			t.Errorf("message %d: %d files", i, len(files))
+		}
+		offered = append(offered, files...)
+	}
+	for i, file := range offered {
+		id, port := uint32(OfferCompleteID), uint16(OfferCompletePort)
+		if i == 3 {
+			id, port = OfferPartialID, OfferPartialPort
+		}
+		if file.Hash[0] != byte(i+1) || file.ClientID != id || file.Port != port {
+			t.Errorf("file %d: %X %#x:%#x", i, file.Hash, file.ClientID, file.Port)
+		}
+		if file.Size() != 1<<33 || len(file.Tags) != 2 {
+			t.Errorf("file %d: size %d, tags %v", i, file.Size(), file.Tags)
+		}
+	}
+
+	// incremental updates.
+	w.messages = nil
+	if err := p.Publish(); err != nil || len(w.messages) != 0 {
+		t.Errorf("publish without changes: %v, %d messages", err, len(w.messages))
+	}
+	p.Share(sharedFiles(true, 4, 5)...)
+	p.Unshare([16]byte{1})
+	if p.Pending() != 2 {
+		t.Errorf("pending %d, want 2", p.Pending())
+	}
+	if err := p.Publish(); err != nil {
+		t.Fatal(err)
+	}
+	if len(w.messages) != 1 || len(w.messages[0].(*OfferFilesMessage).Files) != 2 {
+		t.Fatalf("got messages %v", w.messages)
+	}
+
+	// reconnect with a high ID, without compression and large files.
+	w.messages = nil
+	p.HandleEvent(ServerEvent{Kind: EventDisconnected})
+	p.Share(SharedFile{Hash: [16]byte{6}, Name: "small", Size: 1024, Complete: true})
+	connect(0x02000001, 0)
+	offered = nil
+	for _, m := range w.messages {
+		offered = append(offered, m.(*OfferFilesMessage).Files...)
+	}
+	if len(offered) != 1 || offered[0].Hash[0] != 6 {
+		t.Fatalf("re-published %v, want the small file only", offered)
+	}
+	if file := offered[0]; file.ClientID != 0x02000001 || file.Port != 4662 || file.Size() != 1024 {
+		t.Errorf("small file: %#x:%d, size %d", file.ClientID, file.Port, file.Size())
+	}
+	if p.Pending() != 4 {
+		t.Errorf("pending %d large files, want 4", p.Pending())
+	}
+
+	// low ID, with large files.
+	w.messages = nil
+	connect(0x100, ServerFlagLargeFiles)
+	offered = nil
+	for _, m := range w.messages {
+		offered = append(offered, m.(*OfferFilesMessage).Files...)
+	}
+	if len(offered) != 5 {
+		t.Fatalf("re-published %d files, want 5", len(offered))
+	}
+	for i, file := range offered {
+		if file.ClientID != 0 || file.Port != 0 {
+			t.Errorf("low ID file %d: %#x:%d", i, file.ClientID, file.Port)
+		}
+		if file.Tags.Has(FTFileSizeHi) {
+			t.Errorf("low ID file %d: size hi tag", i)
+		}
+	}
+	if p.Pending() != 0 {
+		t.Errorf("pending %d, want 0", p.Pending())
+	}
+}
+
+func TestPublisherPacked(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	conn := NewConn(client, CSTCPMessage)
+	conn.SetFlags(ServerCapabilities(ServerFlagCompression).Flags())
+	p := NewPublisher(conn, 4662)
+	p.Connected(1, ServerFlagCompression)
+	for i := 0; i < 50; i++ {
+		p.Share(SharedFile{Hash: [16]byte{byte(i)}, Name: "the same file name", Size: 1024, Complete: true})
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- p.Publish() }()
+
+	b := make([]byte, HeaderLength)
+	if _, err := server.Read(b); err != nil {
+		t.Fatal(err)
+	}
+	if b[0] != ProtoPacked {
+		t.Errorf("protocol %#x, want packed", b[0])
+	}
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := server.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPublisherUnlockedWrite(t *testing.T) {
+	w := &messageRecorder{}
+	p := NewPublisher(w, 4662)
+	pending := -1
+	w.onWrite = func(Message) {
+		// the publisher is not locked while writing.
+		pending = p.Pending()
+	}
+	p.Share(sharedFiles(true, 1)...)
+	p.Connected(0x02000001, ServerFlagLargeFiles)
+	if err := p.Publish(); err != nil {
+		t.Fatal(err)
+	}
+	if pending != 1 || p.Pending() != 0 {
+		t.Errorf("pending %d while writing and %d after, want 1 and 0", pending, p.Pending())
+	}
+}
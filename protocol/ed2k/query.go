@@ -0,0 +1,488 @@
+package ed2k
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// QueryError is the error returned by ParseQuery, Pos is the byte offset in the query where the error occurred.
+type QueryError struct {
+	Pos int
+	Msg string
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("query: %s at position %d", e.Msg, e.Pos)
+}
+
+// query token kinds
+const (
+	tokEOF = iota
+	tokWord
+	tokPhrase
+	tokLParen
+	tokRParen
+	tokAND
+	tokOR
+	tokNOT
+)
+
+type queryToken struct {
+	kind int
+	text string
+	// the offset of the token in the query, the position of '-' for negated tokens.
+	pos int
+	// the offset of text in the query.
+	textPos int
+	// the token is prefixed with '-'.
+	neg bool
+}
+
+func (t queryToken) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of query"
+	case tokLParen:
+		return "'('"
+	case tokRParen:
+		return "')'"
+	default:
+		return strconv.Quote(t.text)
+	}
+}
+
+// ParseQuery parses a search query typed by the user to a FileSearcher, the syntax follows the eMule search box:
+//
+//	ubuntu iso                 terms separated by spaces are implicitly AND'ed
+//	"ubuntu desktop"           a quoted phrase is a single term
+//	linux OR bsd               boolean operators AND, OR and NOT must be upper case
+//	ubuntu -beta               a term prefixed with '-' is excluded, the same as "ubuntu NOT beta"
+//	(mp3 OR ogg) live          parentheses group expressions
+//
+// Filters have the form key<op>value where op is one of ':', '=', '>', '<', '>=', '<=' and '!=':
+//
+//	size>700M                  file size, with optional unit suffix B, K, M, G or T (1024 based, fractions allowed)
+//	type:Video                 file type: Audio, Video, Image, Doc, Pro, Arc or Iso
+//	ext:iso                    file extension
+//	sources>=5                 number of sources, "complete" for number of complete sources
+//	length>=30m                media length in seconds or with s, m, h suffix or as [h:]m:s
+//	bitrate>=192               media bitrate in kbps
+//	codec:xvid                 media codec, also artist, album and title
+//
+// OR has the lowest precedence, a query such as "a b OR c" is "(a AND b) OR c".
+func ParseQuery(query string) (s FileSearcher, err error) {
+	tokens, err := tokenizeQuery(query)
+	if err != nil {
+		return
+	}
+	p := &queryParser{tokens: tokens}
+	if s, err = p.parseOr(); err != nil {
+		return
+	}
+	if tok := p.peek(); tok.kind != tokEOF {
+		err = &QueryError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %s", tok)}
+		return
+	}
+	return
+}
+
+func tokenizeQuery(query string) (tokens []queryToken, err error) {
+	i := 0
+	for i < len(query) {
+		c := query[i]
+		switch {
+		case isQuerySpace(c):
+			i++
+			continue
+		case c == '(':
+			tokens = append(tokens, queryToken{kind: tokLParen, text: "(", pos: i, textPos: i})
+			i++
+			continue
+		case c == ')':
+			tokens = append(tokens, queryToken{kind: tokRParen, text: ")", pos: i, textPos: i})
+			i++
+			continue
+		}
+
+		tok := queryToken{kind: tokWord, pos: i}
+		if c == '-' {
+			if i+1 == len(query) || isQuerySpace(query[i+1]) || query[i+1] == ')' {
+				err = &QueryError{Pos: i, Msg: "missing search term after '-'"}
+				return
+			}
+			tok.neg = true
+			i++
+			if query[i] == '(' {
+				tok.kind = tokLParen
+				tok.text = "("
+				tok.textPos = i
+				tokens = append(tokens, tok)
+				i++
+				continue
+			}
+		}
+		tok.textPos = i
+
+		var b strings.Builder
+		quoted, inQuote, quotePos := false, false, 0
+		for ; i < len(query); i++ {
+			c := query[i]
+			if c == '"' {
+				if !inQuote {
+					quotePos = i
+				}
+				inQuote = !inQuote
+				quoted = true
+				continue
+			}
+			if !inQuote && (isQuerySpace(c) || c == '(' || c == ')') {
+				break
+			}
+			b.WriteByte(c)
+		}
+		if inQuote {
+			err = &QueryError{Pos: quotePos, Msg: "unterminated quote"}
+			return
+		}
+		tok.text = b.String()
+
+		// a token which is a single quoted string is a phrase, quotes in a word are just removed.
+		if raw := query[tok.textPos:i]; len(raw) > 0 && raw[0] == '"' && strings.Count(raw, `"`) == 2 && raw[len(raw)-1] == '"' {
+			tok.kind = tokPhrase
+			if tok.text == "" {
+				err = &QueryError{Pos: tok.textPos, Msg: "empty phrase"}
+				return
+			}
+		} else if !quoted && !tok.neg {
+			switch tok.text {
+			case "AND":
+				tok.kind = tokAND
+			case "OR":
+				tok.kind = tokOR
+			case "NOT":
+				tok.kind = tokNOT
+			}
+		}
+		tokens = append(tokens, tok)
+	}
+	tokens = append(tokens, queryToken{kind: tokEOF, pos: len(query), textPos: len(query)})
+	return
+}
+
+func isQuerySpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+type queryParser struct {
+	tokens []queryToken
+	i      int
+}
+
+func (p *queryParser) peek() queryToken {
+	return p.tokens[p.i]
+}
+
+func (p *queryParser) next() queryToken {
+	tok := p.tokens[p.i]
+	if tok.kind != tokEOF {
+		p.i++
+	}
+	return tok
+}
+
+func (p *queryParser) parseOr() (s FileSearcher, err error) {
+	if s, err = p.parseAnd(); err != nil {
+		return
+	}
+	for p.peek().kind == tokOR {
+		p.next()
+		var right FileSearcher
+		if right, err = p.parseAnd(); err != nil {
+			return
+		}
+		s = Or(s, right)
+	}
+	return
+}
+
+func (p *queryParser) parseAnd() (s FileSearcher, err error) {
+	for {
+		tok := p.peek()
+		switch tok.kind {
+		case tokEOF, tokRParen, tokOR:
+			if s == nil {
+				err = &QueryError{Pos: tok.pos, Msg: fmt.Sprintf("missing search term before %s", tok)}
+			}
+			return
+
+		case tokAND:
+			if s == nil {
+				err = &QueryError{Pos: tok.pos, Msg: "missing search term before AND"}
+				return
+			}
+			p.next()
+			switch next := p.peek(); next.kind {
+			case tokWord, tokPhrase, tokLParen, tokNOT:
+			default:
+				err = &QueryError{Pos: next.pos, Msg: fmt.Sprintf("missing search term after AND, got %s", next)}
+				return
+			}
+
+		case tokNOT:
+			if s == nil {
+				err = &QueryError{Pos: tok.pos, Msg: "missing search term before NOT"}
+				return
+			}
+			p.next()
+			var exclude FileSearcher
+			if exclude, err = p.parseOperand(); err != nil {
+				return
+			}
+			s = Not(s, exclude)
+
+		default:
+			var operand FileSearcher
+			if operand, err = p.parseOperand(); err != nil {
+				return
+			}
+			if tok.neg {
+				if s == nil {
+					err = &QueryError{Pos: tok.pos, Msg: "excluded term without search term"}
+					return
+				}
+				s = Not(s, operand)
+			} else {
+				s = And(s, operand)
+			}
+		}
+	}
+}
+
+func (p *queryParser) parseOperand() (s FileSearcher, err error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokLParen:
+		if s, err = p.parseOr(); err != nil {
+			return
+		}
+		if next := p.next(); next.kind != tokRParen {
+			err = &QueryError{Pos: next.pos, Msg: "missing ')'"}
+		}
+	case tokPhrase:
+		s = FileNameSearcher(tok.text)
+	case tokWord:
+		s, err = parseQueryTerm(tok)
+	case tokNOT:
+		err = &QueryError{Pos: tok.pos, Msg: "unexpected NOT, use AND NOT"}
+	default:
+		err = &QueryError{Pos: tok.pos, Msg: fmt.Sprintf("unexpected %s", tok)}
+	}
+	return
+}
+
+// query filter operators, longest first.
+var queryOperators = []struct {
+	text string
+	op   uint8
+}{
+	{">=", SearchGreaterEqual},
+	{"<=", SearchLessEqual},
+	{"!=", SearchNotEqual},
+	{":", SearchEqual},
+	{"=", SearchEqual},
+	{">", SearchGreater},
+	{"<", SearchLess},
+}
+
+// parseQueryTerm parses a word to a filter if it has the form key<op>value with a known key, or to a name term.
+func parseQueryTerm(tok queryToken) (s FileSearcher, err error) {
+	text := tok.text
+	keyEnd := strings.IndexAny(text, ":=<>!")
+	if keyEnd <= 0 {
+		return FileNameSearcher(text), nil
+	}
+	key := strings.ToLower(text[:keyEnd])
+	switch key {
+	case "size", "type", "ext", "extension", "sources", "availability", "complete",
+		"length", "bitrate", "codec", "artist", "album", "title":
+	default:
+		return FileNameSearcher(text), nil
+	}
+
+	var op uint8
+	var opText string
+	for _, o := range queryOperators {
+		if strings.HasPrefix(text[keyEnd:], o.text) {
+			op, opText = o.op, o.text
+			break
+		}
+	}
+	if opText == "" {
+		err = &QueryError{Pos: tok.textPos + keyEnd, Msg: "invalid operator"}
+		return
+	}
+	value := text[keyEnd+len(opText):]
+	pos := tok.textPos + keyEnd + len(opText)
+	if value == "" {
+		err = &QueryError{Pos: pos, Msg: fmt.Sprintf("missing value for %s", key)}
+		return
+	}
+
+	// string filters only support equality.
+	switch key {
+	case "type", "ext", "extension", "codec", "artist", "album", "title":
+		if op != SearchEqual {
+			err = &QueryError{Pos: tok.textPos + keyEnd, Msg: fmt.Sprintf("operator %s not supported for %s", opText, key)}
+			return
+		}
+	}
+
+	switch key {
+	case "size":
+		var size uint64
+		if size, err = parseQuerySize(value, pos); err != nil {
+			return
+		}
+		return Limit(TagSize, op, size), nil
+
+	case "type":
+		fileType, ok := queryFileTypes[strings.ToLower(value)]
+		if !ok {
+			err = &QueryError{Pos: pos, Msg: fmt.Sprintf("unknown file type %q", value)}
+			return
+		}
+		return Type(fileType), nil
+
+	case "ext", "extension":
+		return Extension(value), nil
+
+	case "sources", "availability", "complete", "bitrate":
+		var n uint64
+		if n, err = strconv.ParseUint(value, 10, 32); err != nil {
+			err = &QueryError{Pos: pos, Msg: fmt.Sprintf("invalid number %q", value)}
+			return
+		}
+		name := TagSources
+		switch key {
+		case "complete":
+			name = TagCompleteSources
+		case "bitrate":
+			name = TagMediaBitrate
+		}
+		return Limit(name, op, n), nil
+
+	case "length":
+		var length uint64
+		if length, err = parseQueryLength(value, pos); err != nil {
+			return
+		}
+		return Limit(TagMediaLength, op, length), nil
+
+	case "codec":
+		return Codec(value), nil
+	case "artist":
+		return Metadata(FileMediaArtist, value), nil
+	case "album":
+		return Metadata(FileMediaAlbum, value), nil
+	default: // title
+		return Metadata(FileMediaTitle, value), nil
+	}
+}
+
+var queryFileTypes = map[string]string{
+	"audio":    FileAudio,
+	"video":    FileVideo,
+	"image":    FileImage,
+	"doc":      FileDocument,
+	"document": FileDocument,
+	"pro":      FileProgram,
+	"program":  FileProgram,
+	"arc":      FileArchive,
+	"archive":  FileArchive,
+	"iso":      FileCDImage,
+	"cdimage":  FileCDImage,
+}
+
+var querySizeUnits = map[string]float64{
+	"":    1,
+	"b":   1,
+	"k":   1 << 10,
+	"kb":  1 << 10,
+	"kib": 1 << 10,
+	"m":   1 << 20,
+	"mb":  1 << 20,
+	"mib": 1 << 20,
+	"g":   1 << 30,
+	"gb":  1 << 30,
+	"gib": 1 << 30,
+	"t":   1 << 40,
+	"tb":  1 << 40,
+	"tib": 1 << 40,
+}
+
+func parseQuerySize(value string, pos int) (size uint64, err error) {
+	i := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	if i < 0 {
+		i = len(value)
+	}
+	unit, ok := querySizeUnits[strings.ToLower(value[i:])]
+	if !ok {
+		err = &QueryError{Pos: pos + i, Msg: fmt.Sprintf("unknown size unit %q", value[i:])}
+		return
+	}
+	n, er := strconv.ParseFloat(value[:i], 64)
+	if er != nil || i == 0 {
+		err = &QueryError{Pos: pos, Msg: fmt.Sprintf("invalid size %q", value)}
+		return
+	}
+	n *= unit
+	if n > MaxFileSize {
+		err = &QueryError{Pos: pos, Msg: fmt.Sprintf("size %q too large", value)}
+		return
+	}
+	size = uint64(math.Round(n))
+	return
+}
+
+func parseQueryLength(value string, pos int) (length uint64, err error) {
+	invalid := &QueryError{Pos: pos, Msg: fmt.Sprintf("invalid length %q", value)}
+
+	if strings.Contains(value, ":") {
+		parts := strings.Split(value, ":")
+		if len(parts) > 3 {
+			return 0, invalid
+		}
+		for _, part := range parts {
+			n, er := strconv.ParseUint(part, 10, 32)
+			if er != nil {
+				return 0, invalid
+			}
+			length = length*60 + n
+		}
+	} else {
+		unit := uint64(1)
+		switch value[len(value)-1] {
+		case 's', 'S':
+			value = value[:len(value)-1]
+		case 'm', 'M':
+			unit = 60
+			value = value[:len(value)-1]
+		case 'h', 'H':
+			unit = 3600
+			value = value[:len(value)-1]
+		}
+		n, er := strconv.ParseUint(value, 10, 32)
+		if er != nil {
+			return 0, invalid
+		}
+		length = n * unit
+	}
+	if length > math.MaxUint32 {
+		return 0, invalid
+	}
+	return
+}
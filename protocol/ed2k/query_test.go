@@ -0,0 +1,102 @@
+package ed2k
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseQuery(t *testing.T) {
+	testCases := []struct {
+		in  string
+		out FileSearcher
+	}{
+		{"ubuntu", FileNameSearcher("ubuntu")},
+		{`"ubuntu desktop"`, FileNameSearcher("ubuntu desktop")},
+		{"ubuntu iso", And(FileNameSearcher("ubuntu"), FileNameSearcher("iso"))},
+		{"ubuntu AND iso", And(FileNameSearcher("ubuntu"), FileNameSearcher("iso"))},
+		{"ubuntu and iso", And(FileNameSearcher("ubuntu"), FileNameSearcher("and"), FileNameSearcher("iso"))},
+		{"linux OR bsd", Or(FileNameSearcher("linux"), FileNameSearcher("bsd"))},
+		{"a b OR c", Or(And(FileNameSearcher("a"), FileNameSearcher("b")), FileNameSearcher("c"))},
+		{"a (b OR c)", And(FileNameSearcher("a"), Or(FileNameSearcher("b"), FileNameSearcher("c")))},
+		{"ubuntu -beta", Not(FileNameSearcher("ubuntu"), FileNameSearcher("beta"))},
+		{"ubuntu NOT beta", Not(FileNameSearcher("ubuntu"), FileNameSearcher("beta"))},
+		{"a -(b c)", Not(FileNameSearcher("a"), And(FileNameSearcher("b"), FileNameSearcher("c")))},
+		{
+			"ubuntu iso AND NOT beta size>700M type:Iso sources>=5 ext:iso",
+			And(
+				Not(And(FileNameSearcher("ubuntu"), FileNameSearcher("iso")), FileNameSearcher("beta")),
+				Limit(TagSize, SearchGreater, 700<<20),
+				Type(FileCDImage),
+				MinSources(5),
+				Extension("iso"),
+			),
+		},
+		{"x size<=1.5G", And(FileNameSearcher("x"), MaxSize(3<<29))},
+		{"x size>5GB", And(FileNameSearcher("x"), Limit(TagSize, SearchGreater, 5<<30))},
+		{"x complete>=2 bitrate>=192", And(FileNameSearcher("x"), MinCompleteSources(2), MinBitrate(192))},
+		{"x length>=1:30:00", And(FileNameSearcher("x"), MinLength(5400))},
+		{"x length>=30m", And(FileNameSearcher("x"), MinLength(1800))},
+		{`x codec:"x 264"`, And(FileNameSearcher("x"), Codec("x 264"))},
+		{"x artist:foo", And(FileNameSearcher("x"), Metadata(FileMediaArtist, "foo"))},
+		{"c:drive", FileNameSearcher("c:drive")},
+	}
+
+	for i, tc := range testCases {
+		s, err := ParseQuery(tc.in)
+		if err != nil {
+			t.Errorf("%d: %v", i, err)
+			continue
+		}
+		b1, err := s.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		b2, _ := tc.out.Encode()
+		if !bytes.Equal(b1, b2) {
+			t.Errorf("%d: %q parsed to %v, want %v", i, tc.in, s, tc.out)
+		}
+	}
+}
+
+func TestParseQueryError(t *testing.T) {
+	testCases := []struct {
+		in  string
+		pos int
+	}{
+		{"", 0},
+		{"   ", 3},
+		{"-beta", 0},
+		{"AND a", 0},
+		{"a AND", 5},
+		{"a OR", 4},
+		{"a AND OR b", 6},
+		{"a NOT", 5},
+		{"(a b", 4},
+		{"a b)", 3},
+		{`a "b c`, 2},
+		{`a ""`, 2},
+		{"a size>", 7},
+		{"a size>7X", 8},
+		{"a size>abc", 7},
+		{"a size!5", 6},
+		{"a type:Book", 7},
+		{"a type>Video", 6},
+		{"a sources>=x", 11},
+		{"a length>1:2:3:4", 9},
+		{"a -", 2},
+		{"a -)", 2},
+		{"a - b", 2},
+	}
+
+	for i, tc := range testCases {
+		_, err := ParseQuery(tc.in)
+		qe, ok := err.(*QueryError)
+		if !ok {
+			t.Errorf("%d: %q got %v", i, tc.in, err)
+			continue
+		}
+		if qe.Pos != tc.pos {
+			t.Errorf("%d: %q error %v, want position %d", i, tc.in, qe, tc.pos)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package ed2k
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// messageKey identifies a message type in the registry.
+type messageKey struct {
+	class  int
+	proto  uint8
+	opcode uint8
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[messageKey]func() Message)
+)
+
+func init() {
+	for opcode, fn := range map[uint8]func() Message{
+		MessageLoginRequest:      func() Message { return &LoginMessage{} },
+		MessageServerMessage:     func() Message { return &ServerMessage{} },
+		MessageIDChange:          func() Message { return &IDChangeMessage{} },
+		MessageOfferFiles:        func() Message { return &OfferFilesMessage{} },
+		MessageGetServerList:     func() Message { return &GetServerListMessage{} },
+		MessageServerList:        func() Message { return &ServerListMessage{} },
+		MessageServerStatus:      func() Message { return &ServerStatusMessage{} },
+		MessageServerIdent:       func() Message { return &ServerIdentMessage{} },
+		MessageSearchRequest:     func() Message { return &SearchRequestMessage{} },
+		MessageSearchResult:      func() Message { return &SearchResultMessage{} },
+		MessageMoreResult:        func() Message { return &MoreResultMessage{} },
+		MessageGetSources:        func() Message { return &GetSourcesMessage{} },
+		MessageFoundSources:      func() Message { return &FoundSourcesMessage{} },
+		MessageGetSourcesOBFU:    func() Message { return &GetSourcesOBFUMessage{} },
+		MessageFoundSourcesOBFU:  func() Message { return &FoundSourcesOBFUMessage{} },
+		MessageCallbackRequest:   func() Message { return &CallbackRequestMessage{} },
+		MessageCallbackRequested: func() Message { return &CallbackRequestedMessage{} },
+		MessageCallbackFailed:    func() Message { return &CallbackFailedMessage{} },
+		MessageRejected:          func() Message { return &RejectedMessage{} },
+		MessageSearchUser:        func() Message { return &SearchUserMessage{} },
+		MessageUserList:          func() Message { return &UserListMessage{} },
+	} {
+		RegisterMessage(CSTCPMessage, ProtoEDonkey, opcode, fn)
+	}
+
+	for opcode, fn := range map[uint8]func() Message{
+		MessageHello:              func() Message { return &HelloMessage{} },
+		MessageHelloAnswer:        func() Message { return &HelloAnswerMessage{} },
+		MessageFileRequest:        func() Message { return &FileRequestMessage{} },
+		MessageFileRequestAnswer:  func() Message { return &FileRequestAnswerMessage{} },
+		MessageSetRequestedFileID: func() Message { return &SetRequestedFileIDMessage{} },
+		MessageFileStatus:         func() Message { return &FileStatusMessage{} },
+		MessageNoSuchFile:         func() Message { return &NoSuchFileMessage{} },
+		MessageHashSetRequest:     func() Message { return &HashSetRequestMessage{} },
+		MessageHashSetAnswer:      func() Message { return &HashSetAnswerMessage{} },
+		MessageStartUploadRequest: func() Message { return &StartUploadRequestMessage{} },
+		MessageAcceptUpload:       func() Message { return &AcceptUploadMessage{} },
+		MessageQueueRank:          func() Message { return &QueueRankMessage{} },
+		MessageCancelTransfer:     func() Message { return &CancelTransferMessage{} },
+		MessageOutOfParts:         func() Message { return &OutOfPartsMessage{} },
+		MessageRequestParts:       func() Message { return &RequestPartsMessage{} },
+		MessageSendingPart:        func() Message { return &SendingPartMessage{} },
+		MessageEndOfDownload:      func() Message { return &EndOfDownloadMessage{} },
+	} {
+		RegisterMessage(CCTCPMessage, ProtoEDonkey, opcode, fn)
+	}
+}
+
+// RegisterMessage registers the message constructor fn for the opcode of protocol proto in message class.
+// ReadMessage uses the registry to create the message to decode. A registered message replaces the previous one
+// with the same class, protocol and opcode, so applications can add or override vendor and experimental messages.
+// It panics if fn is nil.
+func RegisterMessage(class int, proto uint8, opcode uint8, fn func() Message) {
+	if fn == nil {
+		panic("ed2k: RegisterMessage with nil message constructor")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	registry[messageKey{class: class, proto: proto, opcode: opcode}] = fn
+}
+
+// NewMessage creates an empty message registered for the opcode of protocol proto in message class,
+// or a RawMessage if no message is registered.
+func NewMessage(class int, proto uint8, opcode uint8) Message {
+	registryMu.RLock()
+	fn, ok := registry[messageKey{class: class, proto: proto, opcode: opcode}]
+	registryMu.RUnlock()
+	if !ok {
+		return &RawMessage{Datagram: class == CSUDPMessage || class == CCUDPMessage}
+	}
+	return fn()
+}
+
+// RawMessage is a message with unknown opcode, the payload is kept as is.
+type RawMessage struct {
+	message
+	Opcode  uint8
+	Payload []byte
+	// Datagram is true for UDP messages, which are encoded without size field.
+	Datagram bool
+}
+
+// Encode encodes the message to binary data.
+func (m *RawMessage) Encode() (data []byte, err error) {
+	buf := new(bytes.Buffer)
+	if m.Datagram {
+		if err = writeDatagramHeader(buf, m.Header, m.Opcode); err != nil {
+			return
+		}
+		buf.Write(m.Payload)
+		data = buf.Bytes()
+		return
+	}
+	if _, err = m.Header.WriteTo(buf); err != nil {
+		return
+	}
+	buf.WriteByte(m.Opcode)
+	buf.Write(m.Payload)
+
+	data = buf.Bytes()
+	size := len(data) - HeaderLength
+	binary.LittleEndian.PutUint32(data[1:5], uint32(size)) // message size
+	return
+}
+
+// Decode decodes the message from binary data.
+func (m *RawMessage) Decode(data []byte) (err error) {
+	if m.Datagram {
+		if len(data) < DatagramHeaderLength {
+			return decodeError(m.Opcode, len(data), "datagram header", ErrShortBuffer)
+		}
+		if m.Header, err = decodeDatagramHeader(data, data[1], 0); err != nil {
+			return
+		}
+		m.Opcode = data[1]
+		m.Payload = append([]byte(nil), data[DatagramHeaderLength:]...)
+		return
+	}
+	if len(data) < HeaderLength+1 {
+		return decodeError(m.Opcode, len(data), "message type", ErrShortBuffer)
+	}
+	header, end, err := decodeMessageHeader(data, data[HeaderLength], 0)
+	if err != nil {
+		return
+	}
+	m.Header = header
+	m.Opcode = data[HeaderLength]
+	m.Payload = nil
+	if pos := HeaderLength + 1; end > pos {
+		m.Payload = append([]byte(nil), data[pos:end]...)
+	}
+	return
+}
+
+// Type is the message type.
+func (m RawMessage) Type() uint8 {
+	return m.Opcode
+}
+
+func (m RawMessage) String() string {
+	b := bytes.Buffer{}
+	b.WriteString("[raw]\n")
+	b.WriteString(m.Header.String())
+	b.WriteString("\n")
+	fmt.Fprintf(&b, "opcode: %#02x, payload: %d bytes", m.Opcode, len(m.Payload))
+	return b.String()
+}
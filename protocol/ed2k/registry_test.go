@@ -0,0 +1,59 @@
+package ed2k
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+type testVendorMessage struct {
+	RawMessage
+}
+
+func TestRegisterMessage(t *testing.T) {
+	RegisterMessage(CSTCPMessage, ProtoEMule, MessageOfferFiles, func() Message { return &testVendorMessage{} })
+	defer func() {
+		registryMu.Lock()
+		delete(registry, messageKey{CSTCPMessage, ProtoEMule, MessageOfferFiles})
+		registryMu.Unlock()
+	}()
+
+	testCases := []struct {
+		data []byte
+		m    Message
+	}{
+		{[]byte{ProtoEDonkey, 5, 0, 0, 0, MessageOfferFiles, 0, 0, 0, 0}, &OfferFilesMessage{}},
+		{[]byte{ProtoEMule, 3, 0, 0, 0, MessageOfferFiles, 1, 2}, &testVendorMessage{}},
+		{[]byte{ProtoEDonkey, 3, 0, 0, 0, 0xEE, 1, 2}, &RawMessage{}},
+		{[]byte{ProtoEMule, 1, 0, 0, 0, MessageLoginRequest}, &RawMessage{}},
+	}
+
+	for i, tc := range testCases {
+		m, err := ReadMessage(bytes.NewReader(tc.data), CSTCPMessage)
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if got, want := fmt.Sprintf("%T", m), fmt.Sprintf("%T", tc.m); got != want {
+			t.Errorf("%d: got %s, want %s", i, got, want)
+		}
+		if m.Type() != tc.data[5] {
+			t.Errorf("%d: type %#x, want %#x", i, m.Type(), tc.data[5])
+		}
+		b, err := m.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(b, tc.data) {
+			t.Errorf("%d: %# x, want %# x", i, b, tc.data)
+		}
+	}
+
+	// a zero protocol byte is read as eDonkey.
+	m, err := ReadMessage(bytes.NewReader([]byte{0, 9, 0, 0, 0, MessageIDChange, 1, 2, 3, 4, 0, 0, 0, 0}), CSTCPMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, ok := m.(*IDChangeMessage); !ok || id.ClientID != 0x04030201 {
+		t.Errorf("got %T %v", m, m)
+	}
+}
@@ -0,0 +1,449 @@
+package ed2k
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxSearchDepth is the maximum nesting depth of a search expression tree.
+	maxSearchDepth = 64
+)
+
+// errors
+var (
+	ErrEmptySearcher     = errors.New("empty searcher")
+	ErrInvalidSearchType = errors.New("invalid search type")
+	ErrInvalidSearchOp   = errors.New("invalid search operator")
+	ErrSearchTooDeep     = errors.New("search expression too deep")
+)
+
+// FileSearcher is a file search struct.
+// A search request is a tree of searchers encoded in prefix order.
+type FileSearcher interface {
+	Encode() (data []byte, err error)
+}
+
+// NameSearcher searches files by a string term matched against file names.
+type NameSearcher struct {
+	Name string
+}
+
+// Encode encodes the searcher to binary data.
+func (s *NameSearcher) Encode() (data []byte, err error) {
+	if s == nil || s.Name == "" {
+		err = ErrEmptySearcher
+		return
+	}
+	data = make([]byte, 3+len(s.Name))
+	data[0] = SearchName
+	binary.LittleEndian.PutUint16(data[1:3], uint16(len(s.Name)))
+	copy(data[3:], s.Name)
+
+	return
+}
+
+func (s *NameSearcher) String() string {
+	if s == nil {
+		return ""
+	}
+	if strings.ContainsAny(s.Name, " \t") {
+		return strconv.Quote(s.Name)
+	}
+	return s.Name
+}
+
+// FileNameSearcher is a file searcher by file name.
+func FileNameSearcher(name string) FileSearcher {
+	return &NameSearcher{Name: name}
+}
+
+// BooleanSearcher combines two searchers with the boolean operator SearchAND, SearchOR or SearchNOT.
+// SearchNOT is a binary operator, it matches files matched by Left but not by Right.
+type BooleanSearcher struct {
+	Operator    uint8
+	Left, Right FileSearcher
+}
+
+// Encode encodes the searcher to binary data.
+func (s *BooleanSearcher) Encode() (data []byte, err error) {
+	if s == nil || s.Left == nil || s.Right == nil {
+		err = ErrEmptySearcher
+		return
+	}
+	if s.Operator > SearchNOT {
+		err = ErrInvalidSearchOp
+		return
+	}
+	left, err := s.Left.Encode()
+	if err != nil {
+		return
+	}
+	right, err := s.Right.Encode()
+	if err != nil {
+		return
+	}
+
+	data = make([]byte, 0, 2+len(left)+len(right))
+	data = append(data, SearchBoolean, s.Operator)
+	data = append(data, left...)
+	data = append(data, right...)
+	return
+}
+
+func (s *BooleanSearcher) String() string {
+	if s == nil {
+		return ""
+	}
+	op := "?"
+	switch s.Operator {
+	case SearchAND:
+		op = "AND"
+	case SearchOR:
+		op = "OR"
+	case SearchNOT:
+		op = "NOT"
+	}
+	return fmt.Sprintf("(%v %s %v)", s.Left, op, s.Right)
+}
+
+// MetadataSearcher searches files by the string value of a metadata tag, e.g. the file type.
+type MetadataSearcher struct {
+	Value string
+	// The tag name, the type of name must be int or string.
+	Tag interface{}
+}
+
+// Encode encodes the searcher to binary data.
+func (s *MetadataSearcher) Encode() (data []byte, err error) {
+	if s == nil {
+		err = ErrEmptySearcher
+		return
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteByte(SearchMetadata)
+	binary.Write(buf, binary.LittleEndian, uint16(len(s.Value)))
+	buf.WriteString(s.Value)
+	if err = writeSearchTag(buf, s.Tag); err != nil {
+		return
+	}
+	data = buf.Bytes()
+	return
+}
+
+func (s *MetadataSearcher) String() string {
+	if s == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%s", searchTagString(s.Tag), s.Value)
+}
+
+// LimitSearcher compares the numeric value of a tag (e.g. file size or source count) with Value,
+// the operator is one of SearchEqual, SearchGreater, SearchLess, SearchGreaterEqual, SearchLessEqual and SearchNotEqual.
+type LimitSearcher struct {
+	Value    uint64
+	Operator uint8
+	// The tag name, the type of name must be int or string.
+	Tag interface{}
+	// Large indicates that the value is encoded as 64-bit integer (SearchLimit64).
+	// It is implied if Value does not fit in 32 bits.
+	Large bool
+}
+
+// Encode encodes the searcher to binary data.
+func (s *LimitSearcher) Encode() (data []byte, err error) {
+	if s == nil {
+		err = ErrEmptySearcher
+		return
+	}
+	if s.Operator > SearchNotEqual {
+		err = ErrInvalidSearchOp
+		return
+	}
+	buf := new(bytes.Buffer)
+	if s.Large || s.Value > math.MaxUint32 {
+		buf.WriteByte(SearchLimit64)
+		binary.Write(buf, binary.LittleEndian, s.Value)
+	} else {
+		buf.WriteByte(SearchLimit)
+		binary.Write(buf, binary.LittleEndian, uint32(s.Value))
+	}
+	buf.WriteByte(s.Operator)
+	if err = writeSearchTag(buf, s.Tag); err != nil {
+		return
+	}
+	data = buf.Bytes()
+	return
+}
+
+func (s *LimitSearcher) String() string {
+	if s == nil {
+		return ""
+	}
+	op := "?"
+	switch s.Operator {
+	case SearchEqual:
+		op = "="
+	case SearchGreater:
+		op = ">"
+	case SearchLess:
+		op = "<"
+	case SearchGreaterEqual:
+		op = ">="
+	case SearchLessEqual:
+		op = "<="
+	case SearchNotEqual:
+		op = "!="
+	}
+	return fmt.Sprintf("%s%s%d", searchTagString(s.Tag), op, s.Value)
+}
+
+// ReadSearcher reads structured binary data from r and parses the data to a search expression tree.
+func ReadSearcher(r io.Reader) (FileSearcher, error) {
+	if r == nil {
+		return nil, io.EOF
+	}
+	return readSearcher(r, 0)
+}
+
+func readSearcher(r io.Reader, depth int) (s FileSearcher, err error) {
+	if depth >= maxSearchDepth {
+		err = ErrSearchTooDeep
+		return
+	}
+
+	var b [8]byte
+	if _, err = io.ReadFull(r, b[:1]); err != nil {
+		return
+	}
+
+	switch b[0] {
+	case SearchBoolean:
+		if _, err = io.ReadFull(r, b[:1]); err != nil {
+			return
+		}
+		if b[0] > SearchNOT {
+			err = ErrInvalidSearchOp
+			return
+		}
+		bs := &BooleanSearcher{Operator: b[0]}
+		if bs.Left, err = readSearcher(r, depth+1); err != nil {
+			return
+		}
+		if bs.Right, err = readSearcher(r, depth+1); err != nil {
+			return
+		}
+		s = bs
+
+	case SearchName:
+		var name string
+		if name, err = readSearchString(r); err != nil {
+			return
+		}
+		s = &NameSearcher{Name: name}
+
+	case SearchMetadata:
+		ms := &MetadataSearcher{}
+		if ms.Value, err = readSearchString(r); err != nil {
+			return
+		}
+		if ms.Tag, err = readSearchTag(r); err != nil {
+			return
+		}
+		s = ms
+
+	case SearchLimit, SearchLimit64:
+		ls := &LimitSearcher{}
+		if b[0] == SearchLimit64 {
+			if _, err = io.ReadFull(r, b[:8]); err != nil {
+				return
+			}
+			ls.Value = binary.LittleEndian.Uint64(b[:8])
+			ls.Large = true
+		} else {
+			if _, err = io.ReadFull(r, b[:4]); err != nil {
+				return
+			}
+			ls.Value = uint64(binary.LittleEndian.Uint32(b[:4]))
+		}
+		if _, err = io.ReadFull(r, b[:1]); err != nil {
+			return
+		}
+		if b[0] > SearchNotEqual {
+			err = ErrInvalidSearchOp
+			return
+		}
+		ls.Operator = b[0]
+		if ls.Tag, err = readSearchTag(r); err != nil {
+			return
+		}
+		s = ls
+
+	default:
+		err = ErrInvalidSearchType
+	}
+	return
+}
+
+func readSearchString(r io.Reader) (string, error) {
+	var b [2]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return "", err
+	}
+	v := make([]byte, binary.LittleEndian.Uint16(b[:]))
+	if _, err := io.ReadFull(r, v); err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+// readSearchTag reads the tag name of a search term, a 1-byte name is a tag ID.
+func readSearchTag(r io.Reader) (name interface{}, err error) {
+	s, err := readSearchString(r)
+	if err != nil {
+		return
+	}
+	if len(s) == 1 {
+		name = int(s[0])
+		return
+	}
+	name = s
+	return
+}
+
+func writeSearchTag(w io.Writer, name interface{}) (err error) {
+	var b []byte
+	switch v := tagName(name).(type) {
+	case int:
+		b = []byte{uint8(v & 0xFF)}
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("invalid name: %v", name)
+	}
+	if err = binary.Write(w, binary.LittleEndian, uint16(len(b))); err != nil {
+		return
+	}
+	_, err = w.Write(b)
+	return
+}
+
+func searchTagString(name interface{}) string {
+	if v, ok := tagName(name).(int); ok {
+		return fmt.Sprintf("%#x", v)
+	}
+	return fmt.Sprint(name)
+}
+
+// And returns a searcher matching files matched by all the searchers, nil searchers are ignored.
+func And(searchers ...FileSearcher) FileSearcher {
+	return combine(SearchAND, searchers)
+}
+
+// Or returns a searcher matching files matched by any of the searchers, nil searchers are ignored.
+func Or(searchers ...FileSearcher) FileSearcher {
+	return combine(SearchOR, searchers)
+}
+
+// Not returns a searcher matching files matched by include but not by exclude.
+func Not(include, exclude FileSearcher) FileSearcher {
+	if exclude == nil {
+		return include
+	}
+	return &BooleanSearcher{Operator: SearchNOT, Left: include, Right: exclude}
+}
+
+// combine folds the searchers from left to right with operator op, the same way eMule does.
+func combine(op uint8, searchers []FileSearcher) (s FileSearcher) {
+	for _, searcher := range searchers {
+		if searcher == nil {
+			continue
+		}
+		if s == nil {
+			s = searcher
+			continue
+		}
+		s = &BooleanSearcher{Operator: op, Left: s, Right: searcher}
+	}
+	return
+}
+
+// Metadata returns a searcher matching files whose tag name has the value.
+// The type of name must be int or string.
+func Metadata(name interface{}, value string) FileSearcher {
+	return &MetadataSearcher{Value: value, Tag: name}
+}
+
+// Type returns a searcher matching files of the media type, e.g. FileVideo.
+// Servers do not know FileArchive and FileCDImage, they are searched as FileProgram like eMule does.
+func Type(fileType string) FileSearcher {
+	if fileType == FileArchive || fileType == FileCDImage {
+		fileType = FileProgram
+	}
+	return Metadata(TagType, fileType)
+}
+
+// Extension returns a searcher matching files with the extension, the leading dot is optional.
+func Extension(ext string) FileSearcher {
+	return Metadata(TagFormat, strings.TrimPrefix(ext, "."))
+}
+
+// Codec returns a searcher matching media files encoded with codec.
+func Codec(codec string) FileSearcher {
+	return Metadata(TagMediaCodec, codec)
+}
+
+// Limit returns a searcher comparing the numeric tag name with value using the operator op.
+// The value is encoded as 64-bit integer if it does not fit in 32 bits.
+func Limit(name interface{}, op uint8, value uint64) FileSearcher {
+	return &LimitSearcher{Value: value, Operator: op, Tag: name}
+}
+
+// MinSize returns a searcher matching files not smaller than size bytes.
+func MinSize(size uint64) FileSearcher {
+	return Limit(TagSize, SearchGreaterEqual, size)
+}
+
+// MaxSize returns a searcher matching files not larger than size bytes.
+func MaxSize(size uint64) FileSearcher {
+	return Limit(TagSize, SearchLessEqual, size)
+}
+
+// SizeRange returns a searcher matching files with size between min and max bytes,
+// a zero bound means no limit on that side.
+func SizeRange(min, max uint64) FileSearcher {
+	var s []FileSearcher
+	if min > 0 {
+		s = append(s, MinSize(min))
+	}
+	if max > 0 {
+		s = append(s, MaxSize(max))
+	}
+	return And(s...)
+}
+
+// MinSources returns a searcher matching files available from at least n sources.
+func MinSources(n uint32) FileSearcher {
+	return Limit(TagSources, SearchGreaterEqual, uint64(n))
+}
+
+// MinCompleteSources returns a searcher matching files with at least n complete sources.
+func MinCompleteSources(n uint32) FileSearcher {
+	return Limit(TagCompleteSources, SearchGreaterEqual, uint64(n))
+}
+
+// MinLength returns a searcher matching media files playing at least seconds long.
+func MinLength(seconds uint32) FileSearcher {
+	return Limit(TagMediaLength, SearchGreaterEqual, uint64(seconds))
+}
+
+// MinBitrate returns a searcher matching media files with bitrate of at least kbps.
+func MinBitrate(kbps uint32) FileSearcher {
+	return Limit(TagMediaBitrate, SearchGreaterEqual, uint64(kbps))
+}
@@ -0,0 +1,127 @@
+package ed2k
+
+import (
+	"context"
+)
+
+// MessageWriter is the interface that wraps the WriteMessage method, it is implemented by Conn.
+type MessageWriter interface {
+	WriteMessage(m Message) error
+}
+
+// SearchSession is a paged search on a server.
+// It sends the search request and collects the result batches, requesting more results
+// with MoreResultMessage until the limit is reached or the server has no more results.
+// Files are deduplicated by hash across batches.
+type SearchSession struct {
+	// Limit is the maximum number of files to collect, zero means no limit.
+	Limit int
+	// Unhandled, if not nil, is called by Run with the messages other than search results.
+	Unhandled func(m Message)
+
+	w        MessageWriter
+	searcher FileSearcher
+	files    []File
+	seen     map[[16]byte]bool
+	more     bool
+	done     bool
+}
+
+// NewSearchSession creates a search session for searcher, the messages are sent to w.
+func NewSearchSession(w MessageWriter, searcher FileSearcher, limit int) *SearchSession {
+	return &SearchSession{
+		Limit:    limit,
+		w:        w,
+		searcher: searcher,
+		seen:     make(map[[16]byte]bool),
+	}
+}
+
+// Start sends the search request.
+func (s *SearchSession) Start() error {
+	if s.searcher == nil {
+		return ErrEmptySearcher
+	}
+	return s.w.WriteMessage(&SearchRequestMessage{Searcher: s.searcher})
+}
+
+// Handle adds the files of the search result batch m to the session.
+// If the limit is not reached and the server has more results, the next batch is requested.
+// The return value done is true if the session does not expect more results.
+// With a ServerSession, which reads the connection, Handle is fed with the results of the EventMessage events.
+func (s *SearchSession) Handle(m *SearchResultMessage) (done bool, err error) {
+	if s.done {
+		return true, nil
+	}
+	for i := range m.Files {
+		if s.full() {
+			break
+		}
+		hash := m.Files[i].Hash
+		if s.seen[hash] {
+			continue
+		}
+		s.seen[hash] = true
+		s.files = append(s.files, m.Files[i])
+	}
+	s.more = m.More
+
+	if s.full() || !s.more {
+		s.done = true
+		return true, nil
+	}
+	if err = s.w.WriteMessage(&MoreResultMessage{}); err != nil {
+		return
+	}
+	return false, nil
+}
+
+func (s *SearchSession) full() bool {
+	return s.Limit > 0 && len(s.files) >= s.Limit
+}
+
+// Run starts the search and reads the results from conn until the session is done or ctx is canceled.
+// It returns the collected files.
+//
+// Run reads conn itself, so it must not be used on a connection read by another goroutine: with a
+// ServerSession, call Start and feed Handle with the search results of the EventMessage events instead.
+// The messages are read with ReadMessageContext, a cancellation in the middle of a message
+// leaves the rest of it in the stream, the connection can't be used anymore and must be closed.
+func (s *SearchSession) Run(ctx context.Context, conn *Conn) ([]File, error) {
+	if err := s.Start(); err != nil {
+		return nil, err
+	}
+
+	for {
+		m, err := conn.ReadMessageContext(ctx)
+		if err != nil {
+			return s.Files(), err
+		}
+		result, ok := m.(*SearchResultMessage)
+		if !ok {
+			if s.Unhandled != nil {
+				s.Unhandled(m)
+			}
+			continue
+		}
+		done, err := s.Handle(result)
+		if err != nil || done {
+			return s.Files(), err
+		}
+	}
+}
+
+// Files returns the files collected so far.
+func (s *SearchSession) Files() []File {
+	return s.files
+}
+
+// More reports whether the server has more results than collected by the session.
+func (s *SearchSession) More() bool {
+	return s.more
+}
+
+// Done reports whether the session does not expect more results.
+func (s *SearchSession) Done() bool {
+	return s.done
+}
@@ -0,0 +1,136 @@
+package ed2k
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// messageRecorder is a MessageWriter recording the messages written.
+type messageRecorder struct {
+	mu       sync.Mutex
+	messages []Message
+	// written, if not nil, receives the messages written.
+	written chan Message
+	// onWrite, if not nil, is called with the message before it is recorded.
+	onWrite func(m Message)
+}
+
+func (r *messageRecorder) WriteMessage(m Message) error {
+	if r.onWrite != nil {
+		r.onWrite(m)
+	}
+	r.mu.Lock()
+	r.messages = append(r.messages, m)
+	r.mu.Unlock()
+	if r.written != nil {
+		r.written <- m
+	}
+	return nil
+}
+
+func resultFiles(ids ...byte) []File {
+	var files []File
+	for _, id := range ids {
+		files = append(files, File{Hash: [16]byte{id}})
+	}
+	return files
+}
+
+func TestSearchSessionHandle(t *testing.T) {
+	testCases := []struct {
+		limit   int
+		batches []*SearchResultMessage
+		files   int
+		more    bool
+		sent    int
+	}{
+		{0, []*SearchResultMessage{{Files: resultFiles(1, 2)}}, 2, false, 1},
+		{0, []*SearchResultMessage{{Files: resultFiles(1, 2), More: true}, {Files: resultFiles(2, 3)}}, 3, false, 2},
+		{3, []*SearchResultMessage{{Files: resultFiles(1, 2), More: true}, {Files: resultFiles(3, 4), More: true}}, 3, true, 2},
+		{2, []*SearchResultMessage{{Files: resultFiles(1, 1, 1, 2, 3), More: true}}, 2, true, 1},
+	}
+
+	for i, tc := range testCases {
+		w := &messageRecorder{}
+		s := NewSearchSession(w, FileNameSearcher("a"), tc.limit)
+		if err := s.Start(); err != nil {
+			t.Fatal(i, err)
+		}
+		for j, batch := range tc.batches {
+			done, err := s.Handle(batch)
+			if err != nil {
+				t.Fatal(i, err)
+			}
+			if done != (j == len(tc.batches)-1) {
+				t.Errorf("%d: batch %d done %v", i, j, done)
+			}
+		}
+		if len(s.Files()) != tc.files || s.More() != tc.more || !s.Done() {
+			t.Errorf("%d: %d files, more %v, done %v", i, len(s.Files()), s.More(), s.Done())
+		}
+		if len(w.messages) != tc.sent {
+			t.Errorf("%d: %d messages sent, want %d", i, len(w.messages), tc.sent)
+		}
+		if _, ok := w.messages[0].(*SearchRequestMessage); !ok {
+			t.Errorf("%d: first message %v", i, w.messages[0])
+		}
+		for _, m := range w.messages[1:] {
+			if _, ok := m.(*MoreResultMessage); !ok {
+				t.Errorf("%d: unexpected message %v", i, m)
+			}
+		}
+	}
+}
+
+func TestSearchSessionRun(t *testing.T) {
+	c1, c2 := net.Pipe()
+	client := NewConn(c1, CSTCPMessage)
+	server := NewConn(c2, CSTCPMessage)
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		batches := []*SearchResultMessage{
+			{Files: resultFiles(1, 2), More: true},
+			{Files: resultFiles(3)},
+		}
+		for _, batch := range batches {
+			if _, err := server.ReadMessage(); err != nil {
+				return
+			}
+			server.WriteMessages(&ServerStatusMessage{}, batch)
+		}
+	}()
+
+	unhandled := 0
+	s := NewSearchSession(client, FileNameSearcher("a"), 0)
+	s.Unhandled = func(Message) { unhandled++ }
+	files, err := s.Run(context.Background(), client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 3 || unhandled != 2 {
+		t.Errorf("got %d files and %d unhandled messages", len(files), unhandled)
+	}
+
+	// canceled while waiting for results, the read timeout does not extend the interrupt.
+	client.ReadTimeout = time.Minute
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	go server.ReadMessage()
+	s = NewSearchSession(client, FileNameSearcher("b"), 0)
+	if _, err := s.Run(ctx, client); err != context.DeadlineExceeded {
+		t.Errorf("got %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// the connection is still usable after the cancellation.
+	go server.WriteMessage(&ServerStatusMessage{UserCount: 1})
+	if m, err := client.ReadMessage(); err != nil {
+		t.Errorf("read after cancel: %v", err)
+	} else if status, ok := m.(*ServerStatusMessage); !ok || status.UserCount != 1 {
+		t.Errorf("read after cancel: %v", m)
+	}
+}
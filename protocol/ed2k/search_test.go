@@ -0,0 +1,189 @@
+package ed2k
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSearchRequestMessageDecode(t *testing.T) {
+	testCases := [][]byte{
+		// ubuntu
+		{SearchName, 6, 0, 'u', 'b', 'u', 'n', 't', 'u'},
+		// ubuntu AND type:Iso
+		{
+			SearchBoolean, SearchAND,
+			SearchName, 6, 0, 'u', 'b', 'u', 'n', 't', 'u',
+			SearchMetadata, 3, 0, 'I', 's', 'o', 1, 0, TagType,
+		},
+		// (ubuntu NOT beta) AND size>=700M AND size<=8G
+		{
+			SearchBoolean, SearchAND,
+			SearchBoolean, SearchAND,
+			SearchBoolean, SearchNOT,
+			SearchName, 6, 0, 'u', 'b', 'u', 'n', 't', 'u',
+			SearchName, 4, 0, 'b', 'e', 't', 'a',
+			SearchLimit, 0x00, 0x00, 0xC0, 0x2B, SearchGreaterEqual, 1, 0, TagSize,
+			SearchLimit64, 0, 0, 0, 0, 2, 0, 0, 0, SearchLessEqual, 1, 0, TagSize,
+		},
+		// a OR length>60, 64-bit encoding of a small value must be kept.
+		{
+			SearchBoolean, SearchOR,
+			SearchName, 1, 0, 'a',
+			SearchLimit64, 60, 0, 0, 0, 0, 0, 0, 0, SearchGreater, 6, 0, 'l', 'e', 'n', 'g', 't', 'h',
+		},
+	}
+
+	for i, tc := range testCases {
+		data := append([]byte{ProtoEDonkey, byte(len(tc) + 1), 0, 0, 0, MessageSearchRequest}, tc...)
+		m := &SearchRequestMessage{}
+		if err := m.Decode(data); err != nil {
+			t.Fatal(i, err)
+		}
+		b, err := m.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Errorf("%d: %s\n%# x\n%# x", i, m, b, data)
+		}
+	}
+}
+
+func TestReadSearcher(t *testing.T) {
+	s, err := ReadSearcher(bytes.NewReader([]byte{
+		SearchBoolean, SearchNOT,
+		SearchName, 1, 0, 'a',
+		SearchLimit, 5, 0, 0, 0, SearchGreaterEqual, 1, 0, 0x15,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs, ok := s.(*BooleanSearcher)
+	if !ok || bs.Operator != SearchNOT {
+		t.Fatalf("unexpected searcher %v", s)
+	}
+	if name, ok := bs.Left.(*NameSearcher); !ok || name.Name != "a" {
+		t.Errorf("unexpected left searcher %v", bs.Left)
+	}
+	limit, ok := bs.Right.(*LimitSearcher)
+	if !ok || limit.Value != 5 || limit.Operator != SearchGreaterEqual || limit.Tag != 0x15 || limit.Large {
+		t.Errorf("unexpected right searcher %v", bs.Right)
+	}
+}
+
+func TestReadSearcherInvalid(t *testing.T) {
+	deep := bytes.Repeat([]byte{SearchBoolean, SearchAND}, maxSearchDepth+1)
+
+	testCases := []struct {
+		in  []byte
+		err error
+	}{
+		{[]byte{0xFF}, ErrInvalidSearchType},
+		{[]byte{SearchBoolean, 0x03}, ErrInvalidSearchOp},
+		{[]byte{SearchLimit, 0, 0, 0, 0, 0x06, 1, 0, TagSize}, ErrInvalidSearchOp},
+		{deep, ErrSearchTooDeep},
+	}
+	for i, tc := range testCases {
+		if _, err := ReadSearcher(bytes.NewReader(tc.in)); err != tc.err {
+			t.Errorf("%d: got %v, want %v", i, err, tc.err)
+		}
+	}
+
+	truncated := [][]byte{
+		{},
+		{SearchBoolean, SearchAND, SearchName, 1, 0, 'a'},
+		{SearchName, 2, 0, 'a'},
+		{SearchMetadata, 1, 0, 'a', 1, 0},
+		{SearchLimit64, 0, 0, 0, 0},
+	}
+	for i, in := range truncated {
+		if _, err := ReadSearcher(bytes.NewReader(in)); err == nil {
+			t.Errorf("%d: truncated input decoded", i)
+		}
+	}
+}
+
+func TestSearcherBuilder(t *testing.T) {
+	testCases := []struct {
+		in  FileSearcher
+		out []byte
+	}{
+		{
+			Type(FileVideo),
+			[]byte{SearchMetadata, 5, 0, 'V', 'i', 'd', 'e', 'o', 1, 0, TagType},
+		},
+		{
+			Type(FileCDImage),
+			[]byte{SearchMetadata, 3, 0, 'P', 'r', 'o', 1, 0, TagType},
+		},
+		{
+			Extension(".iso"),
+			[]byte{SearchMetadata, 3, 0, 'i', 's', 'o', 1, 0, TagFormat},
+		},
+		{
+			MinSources(5),
+			[]byte{SearchLimit, 5, 0, 0, 0, SearchGreaterEqual, 1, 0, TagSources},
+		},
+		{
+			SizeRange(0, 1024),
+			[]byte{SearchLimit, 0, 4, 0, 0, SearchLessEqual, 1, 0, TagSize},
+		},
+		{
+			SizeRange(1, 5<<30),
+			[]byte{
+				SearchBoolean, SearchAND,
+				SearchLimit, 1, 0, 0, 0, SearchGreaterEqual, 1, 0, TagSize,
+				SearchLimit64, 0, 0, 0, 0x40, 1, 0, 0, 0, SearchLessEqual, 1, 0, TagSize,
+			},
+		},
+		{
+			Limit(FTMediaBitrate, SearchGreaterEqual, 128),
+			[]byte{SearchLimit, 128, 0, 0, 0, SearchGreaterEqual, 1, 0, uint8(FTMediaBitrate)},
+		},
+		{
+			Limit(TagSize, LimitMax, 100),
+			[]byte{SearchLimit, 100, 0, 0, 0, 0x02, 1, 0, TagSize},
+		},
+		{
+			Metadata(FTMediaCodec, "x"),
+			[]byte{SearchMetadata, 1, 0, 'x', 1, 0, uint8(FTMediaCodec)},
+		},
+		{
+			And(FileNameSearcher("a"), nil, FileNameSearcher("b"), FileNameSearcher("c")),
+			[]byte{
+				SearchBoolean, SearchAND,
+				SearchBoolean, SearchAND,
+				SearchName, 1, 0, 'a',
+				SearchName, 1, 0, 'b',
+				SearchName, 1, 0, 'c',
+			},
+		},
+		{
+			Not(Or(FileNameSearcher("a"), FileNameSearcher("b")), Metadata("Artist", "x")),
+			[]byte{
+				SearchBoolean, SearchNOT,
+				SearchBoolean, SearchOR,
+				SearchName, 1, 0, 'a',
+				SearchName, 1, 0, 'b',
+				SearchMetadata, 1, 0, 'x', 6, 0, 'A', 'r', 't', 'i', 's', 't',
+			},
+		},
+	}
+
+	for i, tc := range testCases {
+		b, err := tc.in.Encode()
+		if err != nil {
+			t.Fatal(i, err)
+		}
+		if !bytes.Equal(b, tc.out) {
+			t.Errorf("%d: %v\n%# x\n%# x", i, tc.in, b, tc.out)
+		}
+	}
+
+	if s := And(); s != nil {
+		t.Errorf("empty And: %v", s)
+	}
+	if s := Not(FileNameSearcher("a"), nil); s.(*NameSearcher).Name != "a" {
+		t.Errorf("Not without exclude: %v", s)
+	}
+}
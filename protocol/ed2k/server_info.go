@@ -0,0 +1,88 @@
+package ed2k
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ServerInfo is the server description sent in the server ident message tags.
+type ServerInfo struct {
+	Name        string
+	Description string
+	// The server software version, e.g. "17.15".
+	Version string
+	// Server UDP capability flags.
+	UDPFlags uint32
+	// Additional TCP ports the server listens on.
+	AuxPorts []uint16
+	// The ports of the obfuscated protocol, 0 if the server does not support obfuscation.
+	TCPObfuscationPort uint16
+	UDPObfuscationPort uint16
+}
+
+// ParseServerInfo reads the server description from tags.
+// The version tag can be a string or an integer with the major version in the high 16 bits.
+func ParseServerInfo(tags Tags) ServerInfo {
+	info := ServerInfo{}
+	info.Name, _ = tags.String(STServerName)
+	info.Description, _ = tags.String(STDescription)
+	if v, ok := tags.String(STVersion); ok {
+		info.Version = v
+	} else if v, ok := tags.Uint32(STVersion); ok {
+		info.Version = fmt.Sprintf("%d.%02d", v>>16, v&0xFFFF)
+	}
+	info.UDPFlags, _ = tags.Uint32(STUDPFlags)
+	if ports, ok := tags.String(STAuxPortsList); ok {
+		for _, s := range strings.Split(ports, ",") {
+			port, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+			if err != nil || port == 0 {
+				continue
+			}
+			info.AuxPorts = append(info.AuxPorts, uint16(port))
+		}
+	}
+	if port, ok := tags.Uint32(STTCPPortObfuscation); ok {
+		info.TCPObfuscationPort = uint16(port)
+	}
+	if port, ok := tags.Uint32(STUDPPortObfuscation); ok {
+		info.UDPObfuscationPort = uint16(port)
+	}
+	return info
+}
+
+// Tags returns the tags of the server description, zero value fields are omitted.
+func (info *ServerInfo) Tags() Tags {
+	var tags Tags
+	if info.Name != "" {
+		tags = append(tags, StringTag(STServerName, info.Name, false))
+	}
+	if info.Description != "" {
+		tags = append(tags, StringTag(STDescription, info.Description, false))
+	}
+	if info.Version != "" {
+		tags = append(tags, StringTag(STVersion, info.Version, false))
+	}
+	if info.UDPFlags != 0 {
+		tags = append(tags, Uint32Tag(STUDPFlags, info.UDPFlags))
+	}
+	if len(info.AuxPorts) > 0 {
+		var ports []string
+		for _, port := range info.AuxPorts {
+			ports = append(ports, strconv.Itoa(int(port)))
+		}
+		tags = append(tags, StringTag(STAuxPortsList, strings.Join(ports, ","), false))
+	}
+	if info.TCPObfuscationPort != 0 {
+		tags = append(tags, Uint32Tag(STTCPPortObfuscation, uint32(info.TCPObfuscationPort)))
+	}
+	if info.UDPObfuscationPort != 0 {
+		tags = append(tags, Uint32Tag(STUDPPortObfuscation, uint32(info.UDPObfuscationPort)))
+	}
+	return tags
+}
+
+// Info returns the server description of the message tags.
+func (m *ServerIdentMessage) Info() ServerInfo {
+	return ParseServerInfo(m.Tags)
+}
@@ -0,0 +1,41 @@
+package ed2k
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestServerInfo(t *testing.T) {
+	info := ServerInfo{
+		Name:               "eDonkey Server",
+		Description:        "a server",
+		Version:            "17.15",
+		UDPFlags:           0x073B,
+		AuxPorts:           []uint16{4661, 4242},
+		TCPObfuscationPort: 4665,
+		UDPObfuscationPort: 4667,
+	}
+	m := &ServerIdentMessage{Tags: info.Tags()}
+	data, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = &ServerIdentMessage{}
+	if err := m.Decode(data); err != nil {
+		t.Fatal(err)
+	}
+	if got := m.Info(); !reflect.DeepEqual(got, info) {
+		t.Errorf("got %+v, want %+v", got, info)
+	}
+
+	info = ParseServerInfo(Tags{
+		Uint32Tag(STVersion, 17<<16|15),
+		StringTag(STAuxPortsList, "4661, x,0,70000", false),
+	})
+	if info.Version != "17.15" || !reflect.DeepEqual(info.AuxPorts, []uint16{4661}) {
+		t.Errorf("unexpected info %+v", info)
+	}
+	if tags := (&ServerInfo{}).Tags(); len(tags) != 0 {
+		t.Errorf("empty info tags %v", tags)
+	}
+}
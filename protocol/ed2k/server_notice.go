@@ -0,0 +1,95 @@
+package ed2k
+
+import (
+	"strings"
+)
+
+// ServerNoticeKind is the kind of a server message line.
+type ServerNoticeKind int
+
+// Server notice kinds.
+const (
+	NoticeText    ServerNoticeKind = iota // plain text
+	NoticeVersion                         // "server version ...", the text is the server version.
+	NoticeWarning                         // "warning ...", the text is the warning message.
+	NoticeError                           // "error ...", the text is the error message.
+	NoticeDynIP                           // "[emDynIP: host]", the text is the dynamic IP host name of the server.
+)
+
+func (k ServerNoticeKind) String() string {
+	switch k {
+	case NoticeText:
+		return "text"
+	case NoticeVersion:
+		return "version"
+	case NoticeWarning:
+		return "warning"
+	case NoticeError:
+		return "error"
+	case NoticeDynIP:
+		return "emDynIP"
+	}
+	return "unknown"
+}
+
+// ServerNotice is a single line of a server message.
+type ServerNotice struct {
+	Kind ServerNoticeKind
+	// The text of the notice without the prefix.
+	Text string
+	// The raw line.
+	Raw string
+}
+
+// ParseServerMessage splits the server message text s into lines and parses each line, empty lines are skipped.
+// The prefixes are matched case insensitively as eMule does.
+func ParseServerMessage(s string) []ServerNotice {
+	var notices []ServerNotice
+	lines := strings.FieldsFunc(s, func(r rune) bool { return r == '\r' || r == '\n' })
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		notices = append(notices, parseServerNotice(line))
+	}
+	return notices
+}
+
+func parseServerNotice(line string) ServerNotice {
+	notice := ServerNotice{Kind: NoticeText, Text: line, Raw: line}
+	switch {
+	case hasPrefixFold(line, "server version"):
+		notice.Kind = NoticeVersion
+		notice.Text = strings.Trim(line[len("server version"):], " :")
+	case hasPrefixFold(line, "warning"):
+		notice.Kind = NoticeWarning
+		notice.Text = strings.Trim(line[len("warning"):], " :")
+	case hasPrefixFold(line, "error"):
+		notice.Kind = NoticeError
+		notice.Text = strings.Trim(line[len("error"):], " :")
+	default:
+		const dynIP = "[emDynIP: "
+		i := strings.Index(line, dynIP)
+		if i < 0 {
+			break
+		}
+		j := strings.Index(line[i:], "]")
+		if j < 0 {
+			break
+		}
+		if host := strings.TrimSpace(line[i+len(dynIP) : i+j]); host != "" {
+			notice.Kind = NoticeDynIP
+			notice.Text = host
+		}
+	}
+	return notice
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// Notices parses the server message text, see ParseServerMessage.
+func (m *ServerMessage) Notices() []ServerNotice {
+	return ParseServerMessage(m.Messages)
+}
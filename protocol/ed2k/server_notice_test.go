@@ -0,0 +1,43 @@
+package ed2k
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseServerMessage(t *testing.T) {
+	testCases := []struct {
+		in  string
+		out []ServerNotice
+	}{
+		{"", nil},
+		{"\r\n\n", nil},
+		{
+			"server version 17.15 (lugdunum)\r\nWARNING : You have a lowid.\nERROR: invalid login\r\nwelcome",
+			[]ServerNotice{
+				{NoticeVersion, "17.15 (lugdunum)", "server version 17.15 (lugdunum)"},
+				{NoticeWarning, "You have a lowid.", "WARNING : You have a lowid."},
+				{NoticeError, "invalid login", "ERROR: invalid login"},
+				{NoticeText, "welcome", "welcome"},
+			},
+		},
+		{
+			"This server is [emDynIP: server.example.org] \n[emDynIP: ]",
+			[]ServerNotice{
+				{NoticeDynIP, "server.example.org", "This server is [emDynIP: server.example.org] "},
+				{NoticeText, "[emDynIP: ]", "[emDynIP: ]"},
+			},
+		},
+		{
+			"[emDynIP: no closing bracket",
+			[]ServerNotice{{NoticeText, "[emDynIP: no closing bracket", "[emDynIP: no closing bracket"}},
+		},
+	}
+
+	for i, tc := range testCases {
+		m := &ServerMessage{Messages: tc.in}
+		if out := m.Notices(); !reflect.DeepEqual(out, tc.out) {
+			t.Errorf("%d: got %v, want %v", i, out, tc.out)
+		}
+	}
+}
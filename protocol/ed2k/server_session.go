@@ -0,0 +1,339 @@
+package ed2k
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+)
+
+// server session defaults.
+const (
+	DefaultLoginTimeout = 30 * time.Second
+	DefaultKeepAlive    = 15 * time.Minute
+	DefaultMinBackoff   = 5 * time.Second
+	DefaultMaxBackoff   = 5 * time.Minute
+)
+
+// errors
+var (
+	ErrNotConnected   = errors.New("not connected")
+	ErrLoginRejected  = errors.New("login rejected")
+	ErrSessionStarted = errors.New("server session already started")
+)
+
+// ServerEventKind is the kind of a server session event.
+type ServerEventKind int
+
+// Server session event kinds.
+const (
+	EventConnected     ServerEventKind = iota // the login is accepted, Message is the IDChangeMessage.
+	EventDisconnected                         // the connection is closed, Err is the reason.
+	EventServerMessage                        // Message is the ServerMessage.
+	EventServerStatus                         // Message is the ServerStatusMessage.
+	EventServerList                           // Message is the ServerListMessage.
+	EventServerIdent                          // Message is the ServerIdentMessage.
+	EventRejected                             // the server rejected the last command, Message is the RejectedMessage.
+	EventMessage                              // any other message, e.g. search results and found sources.
+)
+
+func (k ServerEventKind) String() string {
+	switch k {
+	case EventConnected:
+		return "connected"
+	case EventDisconnected:
+		return "disconnected"
+	case EventServerMessage:
+		return "server-message"
+	case EventServerStatus:
+		return "server-status"
+	case EventServerList:
+		return "server-list"
+	case EventServerIdent:
+		return "server-ident"
+	case EventRejected:
+		return "rejected"
+	case EventMessage:
+		return "message"
+	}
+	return "unknown"
+}
+
+// ServerEvent is an event of a server session.
+type ServerEvent struct {
+	Kind    ServerEventKind
+	Message Message
+	Err     error
+}
+
+// ServerSession is a client connection to an ed2k server.
+// It dials the server, logs in and waits for the ID change message, then reports the server messages
+// as events. While connected, it sends keepalives and, if Reconnect is set, it reconnects with
+// exponential backoff when the connection is lost.
+//
+// The events must be received from Events while the session runs, otherwise the session blocks.
+// A session runs once, it can be created by NewServerSession or as a struct literal.
+type ServerSession struct {
+	// Addr is the TCP address of the server.
+	Addr string
+	// Login is the login request options.
+	Login LoginOptions
+	// Dialer dials the server, a net.Dialer is used if nil.
+	Dialer interface {
+		DialContext(ctx context.Context, network, address string) (net.Conn, error)
+	}
+	// LoginTimeout is the maximum duration to wait for the ID change message.
+	LoginTimeout time.Duration
+	// KeepAlive is the interval of keepalive messages (an empty offer files message), zero disables keepalives.
+	KeepAlive time.Duration
+	// Reconnect enables reconnecting when the connection is lost.
+	Reconnect bool
+	// MinBackoff and MaxBackoff bound the delay between reconnect attempts.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+
+	eventsOnce sync.Once
+	events     chan ServerEvent
+
+	mu       sync.Mutex
+	started  bool
+	conn     *Conn
+	clientID ClientID
+	caps     ServerCapabilities
+}
+
+// NewServerSession creates a session to the server at addr with the default settings.
+func NewServerSession(addr string, login LoginOptions) *ServerSession {
+	return &ServerSession{
+		Addr:         addr,
+		Login:        login,
+		LoginTimeout: DefaultLoginTimeout,
+		KeepAlive:    DefaultKeepAlive,
+		Reconnect:    true,
+		MinBackoff:   DefaultMinBackoff,
+		MaxBackoff:   DefaultMaxBackoff,
+	}
+}
+
+// Events returns the event stream of the session, it is closed when Run returns.
+func (s *ServerSession) Events() <-chan ServerEvent {
+	return s.eventChan()
+}
+
+// eventChan returns the events channel, it is created on first use.
+func (s *ServerSession) eventChan() chan ServerEvent {
+	s.eventsOnce.Do(func() {
+		s.events = make(chan ServerEvent, 64)
+	})
+	return s.events
+}
+
+// ClientID returns the client ID assigned by the server, it is 0 if not connected.
+func (s *ServerSession) ClientID() ClientID {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.clientID
+}
+
+// LowID reports whether the client ID assigned by the server is a low ID.
+func (s *ServerSession) LowID() bool {
+	return s.ClientID().LowID()
+}
+
+// Capabilities returns the server capabilities sent in the ID change message.
+func (s *ServerSession) Capabilities() ServerCapabilities {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.caps
+}
+
+// WriteMessage sends the message m to the server, it returns ErrNotConnected if the session is not logged in.
+func (s *ServerSession) WriteMessage(m Message) error {
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn == nil {
+		return ErrNotConnected
+	}
+	return conn.WriteMessage(m)
+}
+
+// Run connects to the server and processes the messages until ctx is canceled,
+// or the connection is lost and Reconnect is not set. The events channel is closed on return.
+// Run returns ErrSessionStarted if it is called more than once.
+func (s *ServerSession) Run(ctx context.Context) error {
+	s.mu.Lock()
+	if s.started {
+		s.mu.Unlock()
+		return ErrSessionStarted
+	}
+	s.started = true
+	s.mu.Unlock()
+	events := s.eventChan()
+	defer close(events)
+
+	backoff := s.MinBackoff
+	for {
+		connected, err := s.serve(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !s.emit(ctx, ServerEvent{Kind: EventDisconnected, Err: err}) {
+			return ctx.Err()
+		}
+		if !s.Reconnect {
+			return err
+		}
+
+		if connected {
+			backoff = s.MinBackoff
+		}
+		if backoff <= 0 {
+			backoff = DefaultMinBackoff
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		if backoff *= 2; s.MaxBackoff > 0 && backoff > s.MaxBackoff {
+			backoff = s.MaxBackoff
+		}
+	}
+}
+
+// emit sends the event e, it returns false if ctx is canceled.
+func (s *ServerSession) emit(ctx context.Context, e ServerEvent) bool {
+	select {
+	case s.eventChan() <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// serve runs a single connection, connected is true if the login is accepted.
+func (s *ServerSession) serve(ctx context.Context) (connected bool, err error) {
+	dialer := s.Dialer
+	if dialer == nil {
+		dialer = &net.Dialer{}
+	}
+	nc, err := dialer.DialContext(ctx, "tcp", s.Addr)
+	if err != nil {
+		return
+	}
+	conn := NewConn(nc, CSTCPMessage)
+	defer conn.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	defer func() {
+		s.mu.Lock()
+		s.conn, s.clientID, s.caps = nil, 0, 0
+		s.mu.Unlock()
+	}()
+
+	if err = conn.WriteMessage(NewLoginMessage(s.Login)); err != nil {
+		return
+	}
+	if err = s.login(ctx, conn); err != nil {
+		return
+	}
+	connected = true
+
+	if s.KeepAlive > 0 {
+		go s.keepAlive(ctx, conn)
+	}
+
+	for {
+		var m Message
+		if m, err = conn.ReadMessage(); err != nil {
+			return
+		}
+		if !s.handle(ctx, m) {
+			return connected, ctx.Err()
+		}
+	}
+}
+
+// login reads the messages until the ID change message.
+func (s *ServerSession) login(ctx context.Context, conn *Conn) (err error) {
+	timeout := s.LoginTimeout
+	if timeout <= 0 {
+		timeout = DefaultLoginTimeout
+	}
+	if err = conn.NetConn().SetReadDeadline(time.Now().Add(timeout)); err != nil {
+		return
+	}
+	for {
+		var m Message
+		if m, err = conn.ReadMessage(); err != nil {
+			return
+		}
+		switch msg := m.(type) {
+		case *IDChangeMessage:
+			if err = conn.NetConn().SetReadDeadline(time.Time{}); err != nil {
+				return
+			}
+			caps := msg.Capabilities()
+			conn.SetFlags(caps.Flags())
+			s.mu.Lock()
+			s.conn, s.clientID, s.caps = conn, msg.ClientID, caps
+			s.mu.Unlock()
+			if !s.emit(ctx, ServerEvent{Kind: EventConnected, Message: m}) {
+				return ctx.Err()
+			}
+			return nil
+		case *RejectedMessage:
+			return ErrLoginRejected
+		default:
+			if !s.handle(ctx, m) {
+				return ctx.Err()
+			}
+		}
+	}
+}
+
+// handle reports the message m as event, it returns false if ctx is canceled.
+func (s *ServerSession) handle(ctx context.Context, m Message) bool {
+	kind := EventMessage
+	switch m.(type) {
+	case *ServerMessage:
+		kind = EventServerMessage
+	case *ServerStatusMessage:
+		kind = EventServerStatus
+	case *ServerListMessage:
+		kind = EventServerList
+	case *ServerIdentMessage:
+		kind = EventServerIdent
+	case *RejectedMessage:
+		kind = EventRejected
+	case *NullMessage:
+		return true
+	}
+	return s.emit(ctx, ServerEvent{Kind: kind, Message: m})
+}
+
+func (s *ServerSession) keepAlive(ctx context.Context, conn *Conn) {
+	ticker := time.NewTicker(s.KeepAlive)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := conn.WriteMessage(&OfferFilesMessage{}); err != nil {
+				conn.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package ed2k
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestServerSession(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	logins := make(chan *LoginMessage, 2)
+	keepalives := make(chan struct{}, 1)
+	go func() {
+		for i := 0; i < 2; i++ {
+			nc, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conn := NewConn(nc, CSTCPMessage)
+			m, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			logins <- m.(*LoginMessage)
+			conn.WriteMessages(
+				&ServerMessage{Messages: "server version 17.15"},
+				&IDChangeMessage{ClientID: ClientID(i + 1), Bitmap: ServerFlagCompression | ServerFlagLargeFiles},
+				&ServerStatusMessage{UserCount: 10, FileCount: 20},
+			)
+			if i == 0 {
+				// the first connection is lost.
+				conn.Close()
+				continue
+			}
+			for {
+				m, err := conn.ReadMessage()
+				if err != nil {
+					return
+				}
+				if _, ok := m.(*OfferFilesMessage); ok {
+					select {
+					case keepalives <- struct{}{}:
+					default:
+					}
+				}
+			}
+		}
+	}()
+
+	s := NewServerSession(ln.Addr().String(), LoginOptions{Name: "gmule", Port: 4662})
+	s.MinBackoff = 10 * time.Millisecond
+	s.KeepAlive = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- s.Run(ctx) }()
+
+	want := []ServerEventKind{
+		EventServerMessage, EventConnected, EventServerStatus, EventDisconnected,
+		EventServerMessage, EventConnected, EventServerStatus,
+	}
+	for i, kind := range want {
+		select {
+		case e := <-s.Events():
+			if e.Kind != kind {
+				t.Fatalf("%d: got event %v, want %v", i, e.Kind, kind)
+			}
+			// the first connection may already be closed.
+			if e.Kind == EventConnected && i > 3 {
+				id := e.Message.(*IDChangeMessage).ClientID
+				if s.ClientID() != id || !s.LowID() || !s.Capabilities().LargeFiles() {
+					t.Errorf("%d: session id %v, caps %v", i, s.ClientID(), s.Capabilities())
+				}
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%d: timeout waiting for %v", i, kind)
+		}
+	}
+
+	for i := 0; i < 2; i++ {
+		if login := <-logins; login.Tags.Get(CTName) == nil || login.Port != 4662 {
+			t.Errorf("unexpected login %v", login)
+		}
+	}
+	select {
+	case <-keepalives:
+	case <-time.After(5 * time.Second):
+		t.Error("no keepalive")
+	}
+	if err := s.WriteMessage(&GetServerListMessage{}); err != nil {
+		t.Error(err)
+	}
+
+	cancel()
+	for range s.Events() {
+	}
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	if err := s.WriteMessage(&GetServerListMessage{}); err != ErrNotConnected {
+		t.Errorf("got %v, want %v", err, ErrNotConnected)
+	}
+}
+
+func TestServerSessionRejected(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		nc, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		conn := NewConn(nc, CSTCPMessage)
+		defer conn.Close()
+		conn.ReadMessage()
+		conn.WriteMessage(&RejectedMessage{})
+		conn.ReadMessage()
+	}()
+
+	s := NewServerSession(ln.Addr().String(), LoginOptions{})
+	s.Reconnect = false
+	go func() {
+		for range s.Events() {
+		}
+	}()
+	if err := s.Run(context.Background()); err != ErrLoginRejected {
+		t.Errorf("got %v, want %v", err, ErrLoginRejected)
+	}
+}
+
+func TestServerSessionLiteral(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	s := &ServerSession{Addr: addr}
+	var events []ServerEvent
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for e := range s.Events() {
+			events = append(events, e)
+		}
+	}()
+	if err := s.Run(context.Background()); err == nil {
+		t.Error("dial to a closed listener succeeded")
+	}
+	<-done
+	if len(events) != 1 || events[0].Kind != EventDisconnected {
+		t.Errorf("got events %v, want a single disconnected event", events)
+	}
+
+	if err := s.Run(context.Background()); err != ErrSessionStarted {
+		t.Errorf("second Run: got %v, want %v", err, ErrSessionStarted)
+	}
+}
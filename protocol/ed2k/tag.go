@@ -11,15 +11,21 @@ import (
 
 // tag names
 const (
-	TagName         = 0x01
-	TagSize         = 0x02
-	TagType         = 0x03
-	TagFormat       = 0x04
-	TagDesc         = 0x0B
-	TagVersion      = 0x11
-	TagPort         = 0x0F
-	TagServerFlags  = 0x20 // currently only used to inform a server about supported features.
-	TagEMuleVersion = 0xFB
+	TagName            = 0x01
+	TagSize            = 0x02
+	TagType            = 0x03
+	TagFormat          = 0x04
+	TagDesc            = 0x0B
+	TagVersion         = 0x11
+	TagPort            = 0x0F
+	TagSources         = 0x15
+	TagServerFlags     = 0x20 // currently only used to inform a server about supported features.
+	TagCompleteSources = 0x30
+	TagSizeHi          = 0x3A // high 32 bits of the file size, for large files.
+	TagMediaLength     = 0xD3
+	TagMediaBitrate    = 0xD4
+	TagMediaCodec      = 0xD5
+	TagEMuleVersion    = 0xFB
 
 	// tag flags for internal usage
 
@@ -28,6 +34,12 @@ const (
 	TagCompactNameFlag = 0x100
 )
 
+const (
+	// DefaultMaxTagLength is the default maximum length in bytes of a tag name or value.
+	// It allows strings of any legal length (up to 65535 bytes) while bounding blob allocations.
+	DefaultMaxTagLength = 1 << 20
+)
+
 // Tag types
 const (
 	TagHash16    = 0x01
@@ -106,6 +118,8 @@ type tag struct {
 	tagType uint8
 	name    interface{}
 	value   interface{}
+	// raw is set for a tag of unknown type decoded in lenient mode, the value is the opaque raw bytes.
+	raw bool
 }
 
 func (t *tag) Type() uint8 {
@@ -127,19 +141,84 @@ func (t *tag) Value() interface{} {
 	return t.value
 }
 
+// TagSizeError is returned when the length of a tag name or value is larger than the limit of the TagDecoder.
+type TagSizeError struct {
+	Type   uint8
+	Length int
+	Max    int
+}
+
+func (e *TagSizeError) Error() string {
+	return fmt.Sprintf("tag too large: type %#x, length %d, max %d", e.Type, e.Length, e.Max)
+}
+
+// TagDecoder decodes tags. The message decoders use DefaultTagDecoder unless another one is given
+// to TagDecoder.ReadMessage or Conn.
+type TagDecoder struct {
+	// Lenient keeps a tag of unknown type as opaque raw bytes instead of failing the whole message.
+	// Since the size of an unknown type can't be known, the raw value holds the rest of the tag data:
+	// a raw tag ends the tag list, the tags read before it are kept and the ones following it are lost.
+	Lenient bool
+	// MaxLength is the maximum length in bytes of a tag name or value, DefaultMaxTagLength is used if it is zero.
+	MaxLength int
+}
+
+func (d *TagDecoder) maxLength() int {
+	if d.MaxLength > 0 {
+		return d.MaxLength
+	}
+	return DefaultMaxTagLength
+}
+
+// readBytes reads n bytes of the tag of type tagType from r, n must not exceed the maximum length.
+func (d *TagDecoder) readBytes(r io.Reader, tagType uint8, n int) (b []byte, err error) {
+	if max := d.maxLength(); n > max {
+		err = &TagSizeError{Type: tagType, Length: n, Max: max}
+		return
+	}
+	if n <= bytes.MinRead {
+		b = make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return
+	}
+	// a large length is untrusted, the value is read as it arrives.
+	buf := new(bytes.Buffer)
+	nn, err := io.CopyN(buf, r, int64(n))
+	if err == io.EOF && nn > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	b = buf.Bytes()
+	return
+}
+
+// DefaultTagDecoder is the TagDecoder used by ReadTag and the message decoders.
+// It is shared by all decoders and must not be modified, use a TagDecoder of your own instead.
+var DefaultTagDecoder = &TagDecoder{}
+
 // ReadTag reads structured binary data from r and parses the data to tag.
-func ReadTag(r io.Reader) (Tag, error) {
+func (d *TagDecoder) ReadTag(r io.Reader) (Tag, error) {
 	if r == nil {
 		return nil, io.EOF
 	}
 
 	tag := &tag{}
-	if _, err := tag.ReadFrom(r); err != nil {
+	if _, err := tag.readFrom(r, d); err != nil {
 		return nil, err
 	}
 	return tag, nil
 }
 
+// ReadTag reads structured binary data from r and parses the data to tag using DefaultTagDecoder.
+func ReadTag(r io.Reader) (Tag, error) {
+	return DefaultTagDecoder.ReadTag(r)
+}
+
+// isRawTag reports whether t is a tag of unknown type decoded in lenient mode, which ends the tag list.
+func isRawTag(t Tag) bool {
+	v, ok := t.(*tag)
+	return ok && v.raw
+}
+
 func (t *tag) Encode() (data []byte, err error) {
 	buf := new(bytes.Buffer)
 	if _, err = t.WriteTo(buf); err != nil {
@@ -156,7 +235,11 @@ func (t *tag) Decode(data []byte) (err error) {
 }
 
 func (t *tag) ReadFrom(r io.Reader) (n int64, err error) {
-	b := make([]byte, 1024) // TODO: we should check buffer size when reading from r.
+	return t.readFrom(r, DefaultTagDecoder)
+}
+
+func (t *tag) readFrom(r io.Reader, d *TagDecoder) (n int64, err error) {
+	var b [3]byte
 	if _, err = io.ReadFull(r, b[:1]); err != nil {
 		return
 	}
@@ -177,13 +260,14 @@ func (t *tag) ReadFrom(r io.Reader) (n int64, err error) {
 	}
 
 	if nlen > 0 {
-		if _, err = io.ReadFull(r, b[:nlen]); err != nil {
+		var name []byte
+		if name, err = d.readBytes(r, t.tagType, nlen); err != nil {
 			return
 		}
 		if nlen == 1 {
-			t.name = int(b[0]) | flags
+			t.name = int(name[0]) | flags
 		} else {
-			t.name = string(b[:nlen])
+			t.name = string(name)
 		}
 		n += int64(nlen)
 	} else {
@@ -250,34 +334,96 @@ func (t *tag) ReadFrom(r io.Reader) (n int64, err error) {
 		n += 2
 
 		t.value = ""
-		if vlen > 0 {
-			if _, err = io.ReadFull(r, b[:int(vlen)]); err != nil {
-				return
-			}
-			t.value = string(b[:int(vlen)])
+		var v []byte
+		if v, err = d.readBytes(r, t.tagType, int(vlen)); err != nil {
+			return
 		}
+		t.value = string(v)
 		n += int64(vlen)
 
 	case TagStr1, TagStr2, TagStr3, TagStr4, TagStr5, TagStr6, TagStr7, TagStr8,
 		TagStr9, TagStr10, TagStr11, TagStr12, TagStr13, TagStr14, TagStr15, TagStr16:
 		vlen := int(t.tagType - TagStr0)
-		if _, err = io.ReadFull(r, b[:vlen]); err != nil {
+		var v []byte
+		if v, err = d.readBytes(r, t.tagType, vlen); err != nil {
 			return
 		}
-		t.value = string(b[:vlen])
+		t.value = string(v)
 		n += int64(vlen)
 
 	case TagHash16:
 		vlen := 16
-		if _, err = io.ReadFull(r, b[:vlen]); err != nil {
+		var v []byte
+		if v, err = d.readBytes(r, t.tagType, vlen); err != nil {
+			return
+		}
+		t.value = v
+		n += int64(vlen)
+
+	case TagBlob:
+		var vlen uint32
+		if err = binary.Read(r, binary.LittleEndian, &vlen); err != nil {
+			return
+		}
+		n += 4
+
+		var v []byte
+		if v, err = d.readBytes(r, t.tagType, int(vlen)); err != nil {
+			return
+		}
+		t.value = v
+		n += int64(vlen)
+
+	case TagBsob:
+		var vlen uint8
+		if err = binary.Read(r, binary.LittleEndian, &vlen); err != nil {
+			return
+		}
+		n++
+
+		var v []byte
+		if v, err = d.readBytes(r, t.tagType, int(vlen)); err != nil {
 			return
 		}
-		t.value = b[:vlen]
+		t.value = v
 		n += int64(vlen)
 
+	case TagBoolArray:
+		var count uint16
+		if err = binary.Read(r, binary.LittleEndian, &count); err != nil {
+			return
+		}
+		n += 2
+
+		// eMule always writes count/8+1 bytes.
+		var bits []byte
+		if bits, err = d.readBytes(r, t.tagType, int(count)/8+1); err != nil {
+			return
+		}
+		v := make([]bool, count)
+		for i := range v {
+			v[i] = bits[i/8]&(1<<uint(i%8)) != 0
+		}
+		t.value = v
+		n += int64(len(bits))
+
 	default:
-		err = errors.New("invalid type")
-		return
+		if !d.Lenient {
+			err = errors.New("invalid type")
+			return
+		}
+		max := d.maxLength()
+		var v []byte
+		if v, err = io.ReadAll(io.LimitReader(r, int64(max)+1)); err != nil {
+			return
+		}
+		if len(v) > max {
+			err = &TagSizeError{Type: t.tagType, Length: len(v), Max: max}
+			return
+		}
+		t.value = v
+		t.raw = true
+		n += int64(len(v))
 	}
 	return
 }
@@ -396,14 +542,78 @@ func (t *tag) WriteTo(w io.Writer) (n int64, err error) {
 		n += int64(vlen)
 
 	case TagHash16:
-		v, _ := t.value.([16]byte)
+		var v [16]byte
+		switch value := t.value.(type) {
+		case [16]byte:
+			v = value
+		case []byte:
+			copy(v[:], value)
+		}
 		vlen := len(v)
 		if _, err = w.Write(v[:]); err != nil {
 			return
 		}
 		n += int64(vlen)
 
+	case TagBlob:
+		v, _ := t.value.([]byte)
+		vlen := len(v)
+		if err = binary.Write(w, binary.LittleEndian, uint32(vlen)); err != nil {
+			return
+		}
+		n += 4
+
+		if _, err = w.Write(v); err != nil {
+			return
+		}
+		n += int64(vlen)
+
+	case TagBsob:
+		v, _ := t.value.([]byte)
+		vlen := len(v)
+		if vlen > math.MaxUint8 {
+			err = fmt.Errorf("bsob value too long: %d", vlen)
+			return
+		}
+		if _, err = w.Write([]byte{uint8(vlen)}); err != nil {
+			return
+		}
+		n++
+
+		if _, err = w.Write(v); err != nil {
+			return
+		}
+		n += int64(vlen)
+
+	case TagBoolArray:
+		v, _ := t.value.([]bool)
+		if len(v) > math.MaxUint16 {
+			err = fmt.Errorf("bool array too long: %d", len(v))
+			return
+		}
+		if err = binary.Write(w, binary.LittleEndian, uint16(len(v))); err != nil {
+			return
+		}
+		n += 2
+
+		bits := make([]byte, len(v)/8+1)
+		for i, set := range v {
+			if set {
+				bits[i/8] |= 1 << uint(i%8)
+			}
+		}
+		if _, err = w.Write(bits); err != nil {
+			return
+		}
+		n += int64(len(bits))
+
 	default:
+		if v, ok := t.value.([]byte); ok && t.raw {
+			nn, er := w.Write(v)
+			n += int64(nn)
+			err = er
+			return
+		}
 		err = fmt.Errorf("invalid tag type: %v", t.tagType)
 		return
 	}
@@ -412,7 +622,7 @@ func (t *tag) WriteTo(w io.Writer) (n int64, err error) {
 }
 
 // StringTag is a tag with String value, it supports compressing if length is less than or equal to 16-byte.
-// the type of name must be int or string.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
 func StringTag(name interface{}, value string, compress bool) Tag {
 	types := TagString
 	if len(value) <= 16 && compress {
@@ -421,46 +631,46 @@ func StringTag(name interface{}, value string, compress bool) Tag {
 
 	return &tag{
 		tagType: uint8(types),
-		name:    name,
+		name:    tagName(name),
 		value:   value,
 	}
 }
 
 // BoolTag is a tag with bool value.
-// the type of name must be int or string.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
 func BoolTag(name interface{}, value bool) Tag {
 	return &tag{
 		tagType: TagBool,
-		name:    name,
+		name:    tagName(name),
 		value:   value,
 	}
 }
 
 // Uint8Tag is a tag with uint8 integer value.
-// the type of name must be int or string.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
 func Uint8Tag(name interface{}, value uint8) Tag {
 	return &tag{
 		tagType: TagUint8,
-		name:    name,
+		name:    tagName(name),
 		value:   value,
 	}
 }
 
 // Uint16Tag is a tag with uint16 integer value.
-// the type of name must be int or string.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
 func Uint16Tag(name interface{}, value uint16) Tag {
 	return &tag{
 		tagType: TagUint16,
-		name:    name,
+		name:    tagName(name),
 		value:   value,
 	}
 }
 
 // IntegerTag is a tag with integer value, the actual tag type is based on integer value v.
-// the type of name must be int or string.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
 func IntegerTag(name interface{}, v uint64) Tag {
 	tag := &tag{
-		name: name,
+		name: tagName(name),
 	}
 
 	if v <= math.MaxUint8 {
@@ -480,51 +690,81 @@ func IntegerTag(name interface{}, v uint64) Tag {
 }
 
 // Uint32Tag is a tag with uint32 integer value.
-// the type of name must be int or string.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
 func Uint32Tag(name interface{}, value uint32) Tag {
 	return &tag{
 		tagType: TagUint32,
-		name:    name,
+		name:    tagName(name),
 		value:   value,
 	}
 }
 
 // Uint64Tag is a tag with uint64 integer value.
-// the type of name must be int or string.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
 func Uint64Tag(name interface{}, value uint64) Tag {
 	return &tag{
 		tagType: TagUint64,
-		name:    name,
+		name:    tagName(name),
 		value:   value,
 	}
 }
 
 // FloatTag is a tag with float32 value.
-// the type of name must be int or string.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
 func FloatTag(name interface{}, value float32) Tag {
 	return &tag{
 		tagType: TagFloat32,
-		name:    name,
+		name:    tagName(name),
 		value:   value,
 	}
 }
 
 // Float32Tag is a tag with float32 value.
-// the type of name must be int or string.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
 func Float32Tag(name interface{}, value float32) Tag {
 	return &tag{
 		tagType: TagFloat32,
-		name:    name,
+		name:    tagName(name),
 		value:   value,
 	}
 }
 
 // Hash16Tag is a tag with 16-byte hash value.
-// the type of name must be int or string.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
 func Hash16Tag(name interface{}, value [16]byte) Tag {
 	return &tag{
 		tagType: TagHash16,
-		name:    name,
+		name:    tagName(name),
 		value:   value[:],
 	}
 }
+
+// BlobTag is a tag with binary value.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
+func BlobTag(name interface{}, value []byte) Tag {
+	return &tag{
+		tagType: TagBlob,
+		name:    tagName(name),
+		value:   value,
+	}
+}
+
+// BsobTag is a tag with short binary value, the value must not be longer than 255 bytes.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
+func BsobTag(name interface{}, value []byte) Tag {
+	return &tag{
+		tagType: TagBsob,
+		name:    tagName(name),
+		value:   value,
+	}
+}
+
+// BoolArrayTag is a tag with bool array value.
+// the type of name must be int, string, FileTag, ClientTag, EMuleTag or ServerTag.
+func BoolArrayTag(name interface{}, value []bool) Tag {
+	return &tag{
+		tagType: TagBoolArray,
+		name:    tagName(name),
+		value:   value,
+	}
+}
@@ -3,6 +3,8 @@ package ed2k
 import (
 	"bytes"
 	"io"
+	"net"
+	"reflect"
 	"testing"
 )
 
@@ -373,3 +375,217 @@ func TestStringTagDecode(t *testing.T) {
 		}
 	}
 }
+
+func TestBinaryTagEncode(t *testing.T) {
+	testCases := []struct {
+		in  Tag
+		out []byte
+	}{
+		{BlobTag(1, nil), []byte{TagBlob, 1, 0, 1, 0, 0, 0, 0}},
+		{BlobTag(1, []byte{'a', 'b'}), []byte{TagBlob, 1, 0, 1, 2, 0, 0, 0, 'a', 'b'}},
+		{BsobTag("abc", []byte{'a'}), []byte{TagBsob, 3, 0, 'a', 'b', 'c', 1, 'a'}},
+		{BsobTag(1, make([]byte, 256)), nil},
+		{BoolArrayTag(1, nil), []byte{TagBoolArray, 1, 0, 1, 0, 0, 0}},
+		{BoolArrayTag(1, []bool{true, false, true}), []byte{TagBoolArray, 1, 0, 1, 3, 0, 0x05}},
+		{
+			BoolArrayTag(1, []bool{false, false, false, false, false, false, false, true, true}),
+			[]byte{TagBoolArray, 1, 0, 1, 9, 0, 0x80, 0x01},
+		},
+	}
+
+	for i, tc := range testCases {
+		b, err := tc.in.Encode()
+		if err != nil {
+			t.Log(i, err)
+		}
+		if !bytes.Equal(b, tc.out) {
+			t.Errorf("%d: %# x", i, b)
+		}
+	}
+}
+
+func TestBinaryTagDecode(t *testing.T) {
+	testCases := []struct {
+		in  []byte
+		out Tag
+	}{
+		{[]byte{TagBlob, 1, 0, 1, 0, 0, 0, 0}, BlobTag(1, []byte{})},
+		{[]byte{TagBlob, 1, 0, 1, 2, 0, 0, 0, 'a', 'b'}, BlobTag(1, []byte{'a', 'b'})},
+		{[]byte{TagBlob, 1, 0, 1, 3, 0, 0, 0, 'a', 'b'}, nil},
+		{[]byte{TagBsob | 0x80, 1, 1, 'a'}, BsobTag(1|TagCompactNameFlag, []byte{'a'})},
+		{[]byte{TagBsob, 1, 0, 1, 2, 'a'}, nil},
+		{[]byte{TagBoolArray, 1, 0, 1, 3, 0, 0x05}, BoolArrayTag(1, []bool{true, false, true})},
+		{[]byte{TagBoolArray, 1, 0, 1, 8, 0, 0xFF}, nil},
+		{[]byte{TagBoolArray, 1, 0, 1, 8, 0, 0xFF, 0}, BoolArrayTag(1, []bool{true, true, true, true, true, true, true, true})},
+	}
+
+	for i, tc := range testCases {
+		tag, err := ReadTag(bytes.NewReader(tc.in))
+		if err != nil {
+			t.Log(i, err)
+		}
+		if tag == nil || tc.out == nil {
+			if tag != nil || tc.out != nil {
+				t.Errorf("%d: got %v, want %v", i, tag, tc.out)
+			}
+			continue
+		}
+		if tag.Type() != tc.out.Type() || tag.Name() != tc.out.Name() || !reflect.DeepEqual(tag.Value(), tc.out.Value()) {
+			t.Errorf("%d: got %v, want %v", i, tag, tc.out)
+		}
+	}
+}
+
+func TestLenientTagDecoder(t *testing.T) {
+	data := []byte{0x0C, 1, 0, 1, 'x', 'y', 'z'}
+	if _, err := ReadTag(bytes.NewReader(data)); err == nil {
+		t.Fatal("unknown tag type decoded")
+	}
+
+	d := &TagDecoder{Lenient: true}
+	tag, err := d.ReadTag(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Type() != 0x0C || tag.Name() != 1 || !reflect.DeepEqual(tag.Value(), []byte{'x', 'y', 'z'}) {
+		t.Errorf("unexpected tag %v", tag)
+	}
+	b, err := tag.Encode()
+	if err != nil || !bytes.Equal(b, data) {
+		t.Errorf("raw tag encoded to %# x, %v", b, err)
+	}
+}
+
+func TestLenientHelloMessage(t *testing.T) {
+	m := &HelloMessage{
+		Port: 4662,
+		Tags: []Tag{
+			StringTag(TagName, "gmule", false),
+			&tag{tagType: 0x0C, name: 0x99, value: []byte{1, 2, 3}, raw: true},
+		},
+		Server: &net.TCPAddr{IP: net.IPv4(1, 2, 3, 4), Port: 4661},
+	}
+	data, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := (&HelloMessage{}).Decode(data); err == nil {
+		t.Fatal("unknown tag type decoded")
+	}
+
+	lenient := &TagDecoder{Lenient: true}
+	m2, err := lenient.ReadMessage(bytes.NewReader(data), CCTCPMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hello := m2.(*HelloMessage)
+	if len(hello.Tags) != 2 || hello.Tags[0].Value() != "gmule" || !reflect.DeepEqual(hello.Tags[1].Value(), []byte{1, 2, 3}) {
+		t.Errorf("unexpected tags %v", hello.Tags)
+	}
+	if hello.Server.String() != "1.2.3.4:4661" {
+		t.Errorf("unexpected server %v", hello.Server)
+	}
+}
+
+func TestLenientEMuleInfoAnswer(t *testing.T) {
+	m := &EMuleInfoAnswerMessage{EMuleInfoMessage{
+		ClientVersion: 0x30,
+		Tags:          []Tag{&tag{tagType: 0x0C, name: 0x99, value: []byte{1, 2, 3}, raw: true}},
+	}}
+	data, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lenient := &TagDecoder{Lenient: true}
+	m2, err := lenient.ReadMessage(bytes.NewReader(data), CCTCPMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	answer, ok := m2.(*EMuleInfoAnswerMessage)
+	if !ok || answer.ClientVersion != 0x30 || len(answer.Tags) != 1 || !isRawTag(answer.Tags[0]) {
+		t.Errorf("unexpected message %v", m2)
+	}
+}
+
+func TestReadTagLength(t *testing.T) {
+	long := string(bytes.Repeat([]byte{'a'}, 4000))
+	data, err := StringTag(long, long, false).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tag, err := ReadTag(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tag.Name() != long || tag.Value() != long {
+		t.Error("long string tag mismatch")
+	}
+
+	d := &TagDecoder{MaxLength: 1024}
+	_, err = d.ReadTag(bytes.NewReader(data))
+	if se, ok := err.(*TagSizeError); !ok || se.Length != 4000 || se.Max != 1024 || se.Type != TagString {
+		t.Errorf("got %v, want *TagSizeError", err)
+	}
+
+	// blob length is checked before allocation.
+	_, err = ReadTag(bytes.NewReader([]byte{TagBlob, 1, 0, 1, 0xFF, 0xFF, 0xFF, 0xFF}))
+	if _, ok := err.(*TagSizeError); !ok {
+		t.Errorf("got %v, want *TagSizeError", err)
+	}
+
+	d = &TagDecoder{Lenient: true, MaxLength: 2}
+	_, err = d.ReadTag(bytes.NewReader([]byte{0x0C, 1, 0, 1, 'x', 'y', 'z'}))
+	if _, ok := err.(*TagSizeError); !ok {
+		t.Errorf("got %v, want *TagSizeError", err)
+	}
+}
+
+func TestHash16Tag(t *testing.T) {
+	hash := [16]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	data, err := Hash16Tag(1, hash).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, append([]byte{TagHash16, 1, 0, 1}, hash[:]...)) {
+		t.Fatalf("%# x", data)
+	}
+	tag, err := ReadTag(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(tag.Value().([]byte), hash[:]) {
+		t.Errorf("%# x", tag.Value())
+	}
+}
+
+func TestLenientSearchResult(t *testing.T) {
+	file := func(tags ...Tag) File {
+		return File{Hash: [16]byte{1}, Tags: tags}
+	}
+	m := &SearchResultMessage{Files: []File{
+		file(StringTag(FTFileName, "a", false)),
+		file(StringTag(FTFileName, "b", false), &tag{tagType: 0x0C, name: 0x99, value: []byte{1, 2, 3}, raw: true}, Uint32Tag(FTFileSize, 1)),
+		file(StringTag(FTFileName, "c", false)),
+	}}
+	data, err := m.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the raw tag ends the tag and file lists, the files and tags read before it are kept.
+	lenient := &TagDecoder{Lenient: true}
+	r, err := lenient.ReadMessage(bytes.NewReader(data), CSTCPMessage)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := r.(*SearchResultMessage)
+	if len(result.Files) != 2 || len(result.Files[1].Tags) != 2 || !isRawTag(result.Files[1].Tags[1]) {
+		t.Errorf("unexpected files %v", result.Files)
+	}
+
+	if _, err := ReadMessage(bytes.NewReader(data), CSTCPMessage); err == nil {
+		t.Error("unknown tag type decoded by the default decoder")
+	}
+}
@@ -0,0 +1,421 @@
+package ed2k
+
+import (
+	"fmt"
+)
+
+// FileTag is the name of a file tag (FT_*), it can be used as tag name.
+type FileTag uint8
+
+// File tag names.
+const (
+	FTFileName            FileTag = 0x01
+	FTFileSize            FileTag = 0x02
+	FTFileType            FileTag = 0x03
+	FTFileFormat          FileTag = 0x04
+	FTLastSeenComplete    FileTag = 0x05
+	FTTransferred         FileTag = 0x08
+	FTGapStart            FileTag = 0x09
+	FTGapEnd              FileTag = 0x0A
+	FTDescription         FileTag = 0x0B
+	FTPing                FileTag = 0x0C
+	FTFail                FileTag = 0x0D
+	FTPreference          FileTag = 0x0E
+	FTPort                FileTag = 0x0F
+	FTIP                  FileTag = 0x10
+	FTVersion             FileTag = 0x11
+	FTPartFileName        FileTag = 0x12
+	FTPriority            FileTag = 0x13
+	FTStatus              FileTag = 0x14
+	FTSources             FileTag = 0x15
+	FTPermissions         FileTag = 0x16
+	FTDLPriority          FileTag = 0x18
+	FTULPriority          FileTag = 0x19
+	FTCompression         FileTag = 0x1A
+	FTCorrupted           FileTag = 0x1B
+	FTKadLastPublishKey   FileTag = 0x20
+	FTKadLastPublishSrc   FileTag = 0x21
+	FTFlags               FileTag = 0x22
+	FTDLActiveTime        FileTag = 0x23
+	FTCorruptedParts      FileTag = 0x24
+	FTDLPreview           FileTag = 0x25
+	FTKadLastPublishNotes FileTag = 0x26
+	FTAICHHash            FileTag = 0x27
+	FTFileHash            FileTag = 0x28
+	FTCompleteSources     FileTag = 0x30
+	FTCollectionAuthor    FileTag = 0x31
+	FTCollectionAuthorKey FileTag = 0x32
+	FTPublishInfo         FileTag = 0x33
+	FTLastShared          FileTag = 0x34
+	FTAICHHashSet         FileTag = 0x35
+	FTFileSizeHi          FileTag = 0x3A
+	FTATTransferred       FileTag = 0x50
+	FTATRequested         FileTag = 0x51
+	FTATAccepted          FileTag = 0x52
+	FTCategory            FileTag = 0x53
+	FTATTransferredHi     FileTag = 0x54
+	FTMaxSources          FileTag = 0x55
+	FTMediaArtist         FileTag = 0xD0
+	FTMediaAlbum          FileTag = 0xD1
+	FTMediaTitle          FileTag = 0xD2
+	FTMediaLength         FileTag = 0xD3
+	FTMediaBitrate        FileTag = 0xD4
+	FTMediaCodec          FileTag = 0xD5
+	FTFileComment         FileTag = 0xF6
+	FTFileRating          FileTag = 0xF7
+)
+
+var fileTagNames = map[FileTag]string{
+	FTFileName:            "FT_FILENAME",
+	FTFileSize:            "FT_FILESIZE",
+	FTFileType:            "FT_FILETYPE",
+	FTFileFormat:          "FT_FILEFORMAT",
+	FTLastSeenComplete:    "FT_LASTSEENCOMPLETE",
+	FTTransferred:         "FT_TRANSFERRED",
+	FTGapStart:            "FT_GAPSTART",
+	FTGapEnd:              "FT_GAPEND",
+	FTDescription:         "FT_DESCRIPTION",
+	FTPing:                "FT_PING",
+	FTFail:                "FT_FAIL",
+	FTPreference:          "FT_PREFERENCE",
+	FTPort:                "FT_PORT",
+	FTIP:                  "FT_IP",
+	FTVersion:             "FT_VERSION",
+	FTPartFileName:        "FT_PARTFILENAME",
+	FTPriority:            "FT_PRIORITY",
+	FTStatus:              "FT_STATUS",
+	FTSources:             "FT_SOURCES",
+	FTPermissions:         "FT_PERMISSIONS",
+	FTDLPriority:          "FT_DLPRIORITY",
+	FTULPriority:          "FT_ULPRIORITY",
+	FTCompression:         "FT_COMPRESSION",
+	FTCorrupted:           "FT_CORRUPTED",
+	FTKadLastPublishKey:   "FT_KADLASTPUBLISHKEY",
+	FTKadLastPublishSrc:   "FT_KADLASTPUBLISHSRC",
+	FTFlags:               "FT_FLAGS",
+	FTDLActiveTime:        "FT_DL_ACTIVE_TIME",
+	FTCorruptedParts:      "FT_CORRUPTEDPARTS",
+	FTDLPreview:           "FT_DL_PREVIEW",
+	FTKadLastPublishNotes: "FT_KADLASTPUBLISHNOTES",
+	FTAICHHash:            "FT_AICH_HASH",
+	FTFileHash:            "FT_FILEHASH",
+	FTCompleteSources:     "FT_COMPLETE_SOURCES",
+	FTCollectionAuthor:    "FT_COLLECTIONAUTHOR",
+	FTCollectionAuthorKey: "FT_COLLECTIONAUTHORKEY",
+	FTPublishInfo:         "FT_PUBLISHINFO",
+	FTLastShared:          "FT_LASTSHARED",
+	FTAICHHashSet:         "FT_AICHHASHSET",
+	FTFileSizeHi:          "FT_FILESIZE_HI",
+	FTATTransferred:       "FT_ATTRANSFERRED",
+	FTATRequested:         "FT_ATREQUESTED",
+	FTATAccepted:          "FT_ATACCEPTED",
+	FTCategory:            "FT_CATEGORY",
+	FTATTransferredHi:     "FT_ATTRANSFERREDHI",
+	FTMaxSources:          "FT_MAXSOURCES",
+	FTMediaArtist:         "FT_MEDIA_ARTIST",
+	FTMediaAlbum:          "FT_MEDIA_ALBUM",
+	FTMediaTitle:          "FT_MEDIA_TITLE",
+	FTMediaLength:         "FT_MEDIA_LENGTH",
+	FTMediaBitrate:        "FT_MEDIA_BITRATE",
+	FTMediaCodec:          "FT_MEDIA_CODEC",
+	FTFileComment:         "FT_FILECOMMENT",
+	FTFileRating:          "FT_FILERATING",
+}
+
+func (t FileTag) String() string {
+	if s, ok := fileTagNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("FT_%#02x", uint8(t))
+}
+
+// ClientTag is the name of a client tag (CT_*) used in login and hello messages, it can be used as tag name.
+type ClientTag uint8
+
+// Client tag names.
+const (
+	CTName                 ClientTag = 0x01
+	CTServerUDPSearchFlags ClientTag = 0x0E
+	CTPort                 ClientTag = 0x0F
+	CTVersion              ClientTag = 0x11
+	CTServerFlags          ClientTag = 0x20
+	CTModVersion           ClientTag = 0x55
+	CTEMuleCompatOptions   ClientTag = 0xEF
+	CTEMuleUDPPorts        ClientTag = 0xF9
+	CTEMuleMiscOptions1    ClientTag = 0xFA
+	CTEMuleVersion         ClientTag = 0xFB
+	CTEMuleBuddyIP         ClientTag = 0xFC
+	CTEMuleBuddyUDP        ClientTag = 0xFD
+	CTEMuleMiscOptions2    ClientTag = 0xFE
+)
+
+var clientTagNames = map[ClientTag]string{
+	CTName:                 "CT_NAME",
+	CTServerUDPSearchFlags: "CT_SERVER_UDPSEARCH_FLAGS",
+	CTPort:                 "CT_PORT",
+	CTVersion:              "CT_VERSION",
+	CTServerFlags:          "CT_SERVER_FLAGS",
+	CTModVersion:           "CT_MOD_VERSION",
+	CTEMuleCompatOptions:   "CT_EMULECOMPAT_OPTIONS",
+	CTEMuleUDPPorts:        "CT_EMULE_UDPPORTS",
+	CTEMuleMiscOptions1:    "CT_EMULE_MISCOPTIONS1",
+	CTEMuleVersion:         "CT_EMULE_VERSION",
+	CTEMuleBuddyIP:         "CT_EMULE_BUDDYIP",
+	CTEMuleBuddyUDP:        "CT_EMULE_BUDDYUDP",
+	CTEMuleMiscOptions2:    "CT_EMULE_MISCOPTIONS2",
+}
+
+func (t ClientTag) String() string {
+	if s, ok := clientTagNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("CT_%#02x", uint8(t))
+}
+
+// EMuleTag is the name of an eMule info tag (ET_*) used in eMule info messages, it can be used as tag name.
+// The mod version is sent with the CTModVersion name.
+type EMuleTag uint8
+
+// eMule info tag names.
+const (
+	ETCompression      EMuleTag = 0x20
+	ETUDPPort          EMuleTag = 0x21
+	ETUDPVersion       EMuleTag = 0x22
+	ETSourceExchange   EMuleTag = 0x23
+	ETComments         EMuleTag = 0x24
+	ETExtendedRequest  EMuleTag = 0x25
+	ETCompatibleClient EMuleTag = 0x26
+	ETFeatures         EMuleTag = 0x27
+)
+
+var emuleTagNames = map[EMuleTag]string{
+	ETCompression:      "ET_COMPRESSION",
+	ETUDPPort:          "ET_UDPPORT",
+	ETUDPVersion:       "ET_UDPVER",
+	ETSourceExchange:   "ET_SOURCEEXCHANGE",
+	ETComments:         "ET_COMMENTS",
+	ETExtendedRequest:  "ET_EXTENDEDREQUEST",
+	ETCompatibleClient: "ET_COMPATIBLECLIENT",
+	ETFeatures:         "ET_FEATURES",
+}
+
+func (t EMuleTag) String() string {
+	if s, ok := emuleTagNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("ET_%#02x", uint8(t))
+}
+
+// ServerTag is the name of a server tag (ST_*) used in server descriptions and server.met, it can be used as tag name.
+type ServerTag uint8
+
+// Server tag names.
+const (
+	STServerName         ServerTag = 0x01
+	STDescription        ServerTag = 0x0B
+	STPing               ServerTag = 0x0C
+	STFail               ServerTag = 0x0D
+	STPreference         ServerTag = 0x0E
+	STPort               ServerTag = 0x0F
+	STIP                 ServerTag = 0x10
+	STDynIP              ServerTag = 0x85
+	STMaxUsers           ServerTag = 0x87
+	STSoftFiles          ServerTag = 0x88
+	STHardFiles          ServerTag = 0x89
+	STLastPing           ServerTag = 0x90
+	STVersion            ServerTag = 0x91
+	STUDPFlags           ServerTag = 0x92
+	STAuxPortsList       ServerTag = 0x93
+	STLowIDUsers         ServerTag = 0x94
+	STUDPKey             ServerTag = 0x95
+	STUDPKeyIP           ServerTag = 0x96
+	STTCPPortObfuscation ServerTag = 0x97
+	STUDPPortObfuscation ServerTag = 0x98
+)
+
+var serverTagNames = map[ServerTag]string{
+	STServerName:         "ST_SERVERNAME",
+	STDescription:        "ST_DESCRIPTION",
+	STPing:               "ST_PING",
+	STFail:               "ST_FAIL",
+	STPreference:         "ST_PREFERENCE",
+	STPort:               "ST_PORT",
+	STIP:                 "ST_IP",
+	STDynIP:              "ST_DYNIP",
+	STMaxUsers:           "ST_MAXUSERS",
+	STSoftFiles:          "ST_SOFTFILES",
+	STHardFiles:          "ST_HARDFILES",
+	STLastPing:           "ST_LASTPING",
+	STVersion:            "ST_VERSION",
+	STUDPFlags:           "ST_UDPFLAGS",
+	STAuxPortsList:       "ST_AUXPORTSLIST",
+	STLowIDUsers:         "ST_LOWIDUSERS",
+	STUDPKey:             "ST_UDPKEY",
+	STUDPKeyIP:           "ST_UDPKEYIP",
+	STTCPPortObfuscation: "ST_TCPPORTOBFUSCATION",
+	STUDPPortObfuscation: "ST_UDPPORTOBFUSCATION",
+}
+
+func (t ServerTag) String() string {
+	if s, ok := serverTagNames[t]; ok {
+		return s
+	}
+	return fmt.Sprintf("ST_%#02x", uint8(t))
+}
+
+// tagName converts the typed tag names to the int name used by tags, other names are returned unchanged.
+func tagName(name interface{}) interface{} {
+	switch v := name.(type) {
+	case FileTag:
+		return int(v)
+	case ClientTag:
+		return int(v)
+	case EMuleTag:
+		return int(v)
+	case ServerTag:
+		return int(v)
+	case uint8:
+		return int(v)
+	}
+	return name
+}
+
+// tagNameEqual reports whether the tag t has the name.
+func tagNameEqual(t Tag, name interface{}) bool {
+	switch v := tagName(name).(type) {
+	case int:
+		n, ok := t.Name().(int)
+		return ok && n == v&0xFF
+	case string:
+		n, ok := t.Name().(string)
+		return ok && n == v
+	}
+	return false
+}
+
+// Tags is an ordered collection of tags.
+// The lookup methods accept tag names of type int, string, FileTag, ClientTag, EMuleTag or ServerTag and return the first matching tag.
+type Tags []Tag
+
+// Get returns the tag with the name, or nil if there is no such tag.
+func (tags Tags) Get(name interface{}) Tag {
+	for _, t := range tags {
+		if t != nil && tagNameEqual(t, name) {
+			return t
+		}
+	}
+	return nil
+}
+
+// Has reports whether there is a tag with the name.
+func (tags Tags) Has(name interface{}) bool {
+	return tags.Get(name) != nil
+}
+
+// String returns the value of the string tag with the name.
+func (tags Tags) String(name interface{}) (v string, ok bool) {
+	if t := tags.Get(name); t != nil {
+		v, ok = t.Value().(string)
+	}
+	return
+}
+
+// Uint64 returns the value of the integer tag with the name, the integer tag can be of any size.
+func (tags Tags) Uint64(name interface{}) (v uint64, ok bool) {
+	t := tags.Get(name)
+	if t == nil {
+		return
+	}
+	ok = true
+	switch value := t.Value().(type) {
+	case uint8:
+		v = uint64(value)
+	case uint16:
+		v = uint64(value)
+	case uint32:
+		v = uint64(value)
+	case uint64:
+		v = value
+	default:
+		ok = false
+	}
+	return
+}
+
+// Uint32 returns the value of the integer tag with the name, ok is false if the value does not fit in 32 bits.
+func (tags Tags) Uint32(name interface{}) (v uint32, ok bool) {
+	n, ok := tags.Uint64(name)
+	if !ok || n>>32 != 0 {
+		return 0, false
+	}
+	return uint32(n), true
+}
+
+// Float32 returns the value of the float tag with the name.
+func (tags Tags) Float32(name interface{}) (v float32, ok bool) {
+	if t := tags.Get(name); t != nil {
+		v, ok = t.Value().(float32)
+	}
+	return
+}
+
+// Bool returns the value of the bool tag with the name.
+func (tags Tags) Bool(name interface{}) (v bool, ok bool) {
+	if t := tags.Get(name); t != nil {
+		v, ok = t.Value().(bool)
+	}
+	return
+}
+
+// Bytes returns the value of the hash, blob or bsob tag with the name.
+func (tags Tags) Bytes(name interface{}) (v []byte, ok bool) {
+	t := tags.Get(name)
+	if t == nil {
+		return
+	}
+	switch value := t.Value().(type) {
+	case []byte:
+		return value, true
+	case [16]byte:
+		return value[:], true
+	}
+	return
+}
+
+// Set replaces the first tag with the same name as t, or appends t if there is no such tag.
+func (tags *Tags) Set(t Tag) {
+	for i, tag := range *tags {
+		if tag != nil && tagNameEqual(tag, t.Name()) {
+			(*tags)[i] = t
+			return
+		}
+	}
+	*tags = append(*tags, t)
+}
+
+// SetString sets a string tag, the string is encoded compressed if compress is true.
+func (tags *Tags) SetString(name interface{}, v string, compress bool) {
+	tags.Set(StringTag(name, v, compress))
+}
+
+// SetUint32 sets a uint32 tag.
+func (tags *Tags) SetUint32(name interface{}, v uint32) {
+	tags.Set(Uint32Tag(name, v))
+}
+
+// SetInteger sets an integer tag with the smallest integer type that fits v.
+func (tags *Tags) SetInteger(name interface{}, v uint64) {
+	tags.Set(IntegerTag(name, v))
+}
+
+// Delete removes all the tags with the name.
+func (tags *Tags) Delete(name interface{}) {
+	s := (*tags)[:0]
+	for _, t := range *tags {
+		if t != nil && tagNameEqual(t, name) {
+			continue
+		}
+		s = append(s, t)
+	}
+	*tags = s
+}
@@ -0,0 +1,87 @@
+package ed2k
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestTags(t *testing.T) {
+	var tags Tags
+	tags.Set(StringTag(FTFileName, "ubuntu.iso", false))
+	tags.Set(IntegerTag(FTFileSize, 700<<20))
+	tags.Set(StringTag("Artist", "foo", true))
+	tags.Set(Float32Tag(0x15, 1.5))
+	tags.Set(BoolTag(CTServerFlags, true))
+	tags.Set(Hash16Tag(FTAICHHash, [16]byte{1, 2, 3}))
+
+	if s, ok := tags.String(TagName); !ok || s != "ubuntu.iso" {
+		t.Errorf("String: %q %v", s, ok)
+	}
+	if s, ok := tags.String("Artist"); !ok || s != "foo" {
+		t.Errorf("String: %q %v", s, ok)
+	}
+	if _, ok := tags.String(FTFileSize); ok {
+		t.Error("String of an integer tag")
+	}
+	if n, ok := tags.Uint64(FTFileSize); !ok || n != 700<<20 {
+		t.Errorf("Uint64: %d %v", n, ok)
+	}
+	if n, ok := tags.Uint32(TagSize); !ok || n != 700<<20 {
+		t.Errorf("Uint32: %d %v", n, ok)
+	}
+	if f, ok := tags.Float32(FTSources); !ok || f != 1.5 {
+		t.Errorf("Float32: %v %v", f, ok)
+	}
+	if b, ok := tags.Bool(0x20); !ok || !b {
+		t.Errorf("Bool: %v %v", b, ok)
+	}
+	if b, ok := tags.Bytes(FTAICHHash); !ok || !bytes.Equal(b, []byte{1, 2, 3, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0}) {
+		t.Errorf("Bytes: %v %v", b, ok)
+	}
+	if tags.Has(FTMediaArtist) || tags.Has("artist") {
+		t.Error("Has: unexpected tag")
+	}
+
+	tags.SetInteger(FTFileSize, 5<<30)
+	if n, ok := tags.Uint64(FTFileSize); !ok || n != 5<<30 || len(tags) != 6 {
+		t.Errorf("Set: %d %v %d", n, ok, len(tags))
+	}
+	if _, ok := tags.Uint32(FTFileSize); ok {
+		t.Error("Uint32 of a 64-bit value")
+	}
+	if tags[1].Name() != TagSize {
+		t.Errorf("Set changed the tag order: %v", tags[1].Name())
+	}
+
+	tags.Delete(FTFileName)
+	if tags.Has(FTFileName) || len(tags) != 5 || tags[0].Name() != TagSize {
+		t.Errorf("Delete: %d tags", len(tags))
+	}
+
+	buf := new(bytes.Buffer)
+	for _, tag := range tags {
+		if _, err := tag.WriteTo(buf); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTagNameString(t *testing.T) {
+	testCases := []struct {
+		name interface{ String() string }
+		s    string
+	}{
+		{FTFileName, "FT_FILENAME"},
+		{FTFileSizeHi, "FT_FILESIZE_HI"},
+		{FileTag(0x99), "FT_0x99"},
+		{CTEMuleMiscOptions1, "CT_EMULE_MISCOPTIONS1"},
+		{ClientTag(0x77), "CT_0x77"},
+		{ETCompatibleClient, "ET_COMPATIBLECLIENT"},
+		{EMuleTag(0x77), "ET_0x77"},
+	}
+	for i, tc := range testCases {
+		if s := tc.name.String(); s != tc.s {
+			t.Errorf("%d: got %s, want %s", i, s, tc.s)
+		}
+	}
+}